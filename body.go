@@ -0,0 +1,408 @@
+package eprouter
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/amattn/deeperror"
+)
+
+// Request body decoding
+//
+//	type BookPayload struct {
+//		Name     string   `json:"name" validate:"required,max=200"`
+//		AuthorId uint64   `json:"authorId" validate:"required"`
+//		Tags     []string `json:"tags" validate:"max=10"`
+//		Status   string   `json:"status" validate:"oneof=draft published"`
+//	}
+//
+//	var book BookPayload
+//	if err := ctx.DecodeRequestBody(&book); err != nil {
+//		return ctx.MakeRouteHandlerResultFromError(err)
+//	}
+//
+// validate rules are comma separated:
+//	required     must not be the zero value (nil, "", 0, false, empty slice or map)
+//	min=n, max=n numbers are compared by value; strings (in runes), slices and maps by length
+//	oneof=a b c  space separated list of allowed values for strings and numbers
+// Nested structs, pointers to structs and slices of structs are validated as well.  Field errors use the json name,
+// eg: "author.name" or "chapters[2].title".
+// The reflection happens once per type; validations are cached.
+
+const (
+	BadRequestInvalidBodyErrorNumber = 4000000006
+	BadRequestInvalidBodyPrefix      = BadRequestPrefix + ": Invalid Body"
+	UnsupportedMediaTypePrefix       = "415 Unsupported Media Type"
+	UnsupportedMediaTypeErrorNumber  = 4150000415
+)
+
+type DecodeOptions struct {
+	// Unknown fields in the body are a 400, instead of being ignored.
+	DisallowUnknownFields bool
+
+	// A request w/o a Content-Type header is a 415, instead of being treated as JSON.
+	// A Content-Type other than JSON (application/json or any +json type) is always a 415.
+	RequireContentType bool
+
+	// Typed handlers (eg: PostHandlerV1(ctx, book *BookPayload)) decode their body w/ DecodeRequestBody as well, ie: they
+	// also check the Content-Type and run validate tags.  Off by default, in which case their body is only unmarshaled,
+	// whatever the Content-Type.  Turning it on is a breaking change for clients which send eg: text/plain.
+	ApplyToTypedHandlers bool
+}
+
+// Decodes the JSON request body into dst (a pointer), then runs its validate tags.  Uses Router.DecodeOptions.
+// returns a *RouteError on bad input (415 for the wrong Content-Type, 400 otherwise, w/ ErrorInfo.FieldErrors when
+// specific fields are at fault), or a *deeperror.DeepError (500) if dst can't be decoded into or has malformed tags.
+// Either can be passed straight to ctx.MakeRouteHandlerResultFromError.
+// The body is read via RequestBody(), so it stays available to later calls.
+func (ctx *Context) DecodeRequestBody(dst interface{}) error {
+	options := DecodeOptions{}
+	if ctx.router != nil {
+		options = ctx.router.DecodeOptions
+	}
+	return ctx.DecodeRequestBodyWithOptions(dst, options)
+}
+
+func (ctx *Context) DecodeRequestBodyWithOptions(dst interface{}, options DecodeOptions) error {
+	dstValue := reflect.ValueOf(dst)
+	if dstValue.Kind() != reflect.Ptr || dstValue.IsNil() {
+		return deeperror.NewHTTPError(1702265433, InternalServerErrorPrefix, fmt.Errorf("DecodeRequestBody expects a non-nil pointer, got %T", dst), http.StatusInternalServerError)
+	}
+
+	if rerr := checkJSONContentType(ctx.Req.Header.Get(HttpHeaderContentType), options.RequireContentType); rerr != nil {
+		return rerr
+	}
+
+	bodyBytes, err := ctx.RequestBody()
+	if rerr, isRouteError := err.(*RouteError); isRouteError {
+		// eg: 413
+		return rerr
+	} else if err != nil {
+		return NewRouteError(http.StatusBadRequest, ErrorInfo{ErrorNumber: 1702265430, ErrorMessage: BadRequestPrefix + ": Cannot read body"})
+	}
+	if len(bodyBytes) == 0 {
+		return NewRouteError(http.StatusBadRequest, ErrorInfo{ErrorNumber: 1702265431, ErrorMessage: BadRequestPrefix + ": Expected non-empty body"})
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(bodyBytes))
+	if options.DisallowUnknownFields {
+		decoder.DisallowUnknownFields()
+	}
+	err = decoder.Decode(dst)
+	if err == nil {
+		// like json.Unmarshal, nothing but whitespace may follow
+		if _, trailingErr := decoder.Token(); trailingErr != io.EOF {
+			err = errors.New("unexpected data after the top-level value")
+		}
+	}
+	if err != nil {
+		derr := deeperror.New(1702265432, BadRequestPrefix+": Cannot parse body", err)
+		log.Println("derr", derr)
+		errInfo := ErrorInfo{ErrorNumber: derr.Num, ErrorMessage: derr.EndUserMsg}
+		if fieldError, isFieldError := fieldErrorFromDecodeError(err); isFieldError {
+			errInfo.FieldErrors = []FieldError{fieldError}
+		}
+		return NewRouteError(http.StatusBadRequest, errInfo)
+	}
+
+	fieldErrors, err := validateValue(dstValue, "")
+	if err != nil {
+		return deeperror.NewHTTPError(1702265434, InternalServerErrorPrefix, err, http.StatusInternalServerError)
+	}
+	if len(fieldErrors) > 0 {
+		return NewRouteError(http.StatusBadRequest, ErrorInfo{
+			ErrorNumber:  BadRequestInvalidBodyErrorNumber,
+			ErrorMessage: BadRequestInvalidBodyPrefix,
+			FieldErrors:  fieldErrors,
+		})
+	}
+	return nil
+}
+
+// application/json, or any structured +json type (eg: application/vnd.collectivehealth.book.v2+json)
+func checkJSONContentType(contentType string, required bool) *RouteError {
+	if contentType == "" {
+		if required {
+			return NewRouteError(http.StatusUnsupportedMediaType, ErrorInfo{ErrorNumber: UnsupportedMediaTypeErrorNumber, ErrorMessage: UnsupportedMediaTypePrefix, DebugMessage: "missing Content-Type, expected " + HttpHeaderContentTypeJSON})
+		}
+		return nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || (mediaType != HttpHeaderContentTypeJSON && strings.HasSuffix(mediaType, "+json") == false) {
+		return NewRouteError(http.StatusUnsupportedMediaType, ErrorInfo{ErrorNumber: UnsupportedMediaTypeErrorNumber, ErrorMessage: UnsupportedMediaTypePrefix, DebugMessage: "expected " + HttpHeaderContentTypeJSON + ", got " + contentType})
+	}
+	return nil
+}
+
+func fieldErrorFromDecodeError(err error) (FieldError, bool) {
+	if typeErr, isTypeErr := err.(*json.UnmarshalTypeError); isTypeErr && typeErr.Field != "" {
+		return FieldError{Field: typeErr.Field, Message: "expected " + typeErr.Type.String() + ", got " + typeErr.Value}, true
+	}
+	// encoding/json doesn't export a type for this one
+	const unknownFieldPrefix = "json: unknown field "
+	if strings.HasPrefix(err.Error(), unknownFieldPrefix) {
+		field, unquoteErr := strconv.Unquote(strings.TrimPrefix(err.Error(), unknownFieldPrefix))
+		if unquoteErr == nil {
+			return FieldError{Field: field, Message: "unknown field"}, true
+		}
+	}
+	return FieldError{}, false
+}
+
+// Validation
+
+type validationRule func(value reflect.Value) (message string)
+
+type validatedField struct {
+	index int
+	name  string // json name
+	rules []validationRule
+}
+
+type structValidation struct {
+	fields []validatedField
+}
+
+var structValidationCache sync.Map // key is reflect.Type of the struct, value is *structValidation
+
+// walks pointers, structs and slices.  path is the json path of value, "" for the top level
+func validateValue(value reflect.Value, path string) ([]FieldError, error) {
+	switch value.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if value.IsNil() {
+			return nil, nil
+		}
+		return validateValue(value.Elem(), path)
+	case reflect.Slice, reflect.Array:
+		switch value.Type().Elem().Kind() {
+		case reflect.Struct, reflect.Ptr, reflect.Interface, reflect.Slice, reflect.Array:
+		default:
+			// nothing to walk into
+			return nil, nil
+		}
+		var fieldErrors []FieldError
+		for i := 0; i < value.Len(); i++ {
+			elemErrors, err := validateValue(value.Index(i), path+"["+strconv.Itoa(i)+"]")
+			if err != nil {
+				return nil, err
+			}
+			fieldErrors = append(fieldErrors, elemErrors...)
+		}
+		return fieldErrors, nil
+	case reflect.Struct:
+		if value.Type() == timeType {
+			return nil, nil
+		}
+		validation, err := structValidationFor(value.Type())
+		if err != nil {
+			return nil, err
+		}
+		var fieldErrors []FieldError
+		for _, field := range validation.fields {
+			fieldPath := field.name
+			if path != "" {
+				fieldPath = path + "." + field.name
+			}
+			fieldValue := value.Field(field.index)
+			failed := false
+			for _, rule := range field.rules {
+				if message := rule(fieldValue); message != "" {
+					fieldErrors = append(fieldErrors, FieldError{Field: fieldPath, Message: message})
+					failed = true
+					break
+				}
+			}
+			if failed {
+				continue
+			}
+			nestedErrors, err := validateValue(fieldValue, fieldPath)
+			if err != nil {
+				return nil, err
+			}
+			fieldErrors = append(fieldErrors, nestedErrors...)
+		}
+		return fieldErrors, nil
+	}
+	return nil, nil
+}
+
+func structValidationFor(structType reflect.Type) (*structValidation, error) {
+	if cached, exists := structValidationCache.Load(structType); exists {
+		return cached.(*structValidation), nil
+	}
+	validation, err := makeStructValidation(structType)
+	if err != nil {
+		return nil, err
+	}
+	// if two requests race here, both validations are identical, so it doesn't matter who wins
+	structValidationCache.Store(structType, validation)
+	return validation, nil
+}
+
+func makeStructValidation(structType reflect.Type) (*structValidation, error) {
+	validation := new(structValidation)
+	for i := 0; i < structType.NumField(); i++ {
+		structField := structType.Field(i)
+		if structField.PkgPath != "" {
+			// unexported
+			continue
+		}
+		name := structField.Name
+		jsonName := strings.Split(structField.Tag.Get("json"), ",")[0]
+		if jsonName == "-" {
+			continue
+		} else if jsonName != "" {
+			name = jsonName
+		}
+
+		field := validatedField{index: i, name: name}
+		tag := structField.Tag.Get("validate")
+		if tag != "" && tag != "-" {
+			for _, option := range strings.Split(tag, ",") {
+				rule, err := makeValidationRule(structField.Type, option)
+				if err != nil {
+					return nil, fmt.Errorf("%s.%s: %s", structType, structField.Name, err)
+				}
+				field.rules = append(field.rules, rule)
+			}
+		}
+		validation.fields = append(validation.fields, field)
+	}
+	return validation, nil
+}
+
+func makeValidationRule(fieldType reflect.Type, option string) (validationRule, error) {
+	ruleName, argument := option, ""
+	if equalsIndex := strings.Index(option, "="); equalsIndex >= 0 {
+		ruleName, argument = option[:equalsIndex], option[equalsIndex+1:]
+	}
+
+	if ruleName == "required" {
+		return func(value reflect.Value) string {
+			if value.IsZero() || ((value.Kind() == reflect.Slice || value.Kind() == reflect.Map) && value.Len() == 0) {
+				return "required"
+			}
+			return ""
+		}, nil
+	}
+
+	// the remaining rules don't apply to nil pointers; use required for that
+	elemType := fieldType
+	if elemType.Kind() == reflect.Ptr {
+		elemType = elemType.Elem()
+	}
+	deref := func(rule validationRule) validationRule {
+		return func(value reflect.Value) string {
+			if value.Kind() == reflect.Ptr {
+				if value.IsNil() {
+					return ""
+				}
+				value = value.Elem()
+			}
+			return rule(value)
+		}
+	}
+
+	switch ruleName {
+	case "min", "max":
+		limit, err := strconv.ParseFloat(argument, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s expects a number, got %q", ruleName, argument)
+		}
+		isMin := ruleName == "min"
+		describe := func(what string) string {
+			if isMin {
+				return fmt.Sprintf("must be at least %s%s", argument, what)
+			}
+			return fmt.Sprintf("must be at most %s%s", argument, what)
+		}
+		outOfRange := func(measured float64) bool {
+			return (isMin && measured < limit) || (isMin == false && measured > limit)
+		}
+
+		switch elemType.Kind() {
+		case reflect.String:
+			return deref(func(value reflect.Value) string {
+				if outOfRange(float64(utf8.RuneCountInString(value.String()))) {
+					return describe(" characters")
+				}
+				return ""
+			}), nil
+		case reflect.Slice, reflect.Array, reflect.Map:
+			return deref(func(value reflect.Value) string {
+				if outOfRange(float64(value.Len())) {
+					return describe(" items")
+				}
+				return ""
+			}), nil
+		}
+		if measure := numericMeasure(elemType); measure != nil {
+			return deref(func(value reflect.Value) string {
+				if outOfRange(measure(value)) {
+					return describe("")
+				}
+				return ""
+			}), nil
+		}
+		return nil, fmt.Errorf("%s does not apply to %s", ruleName, fieldType)
+	case "oneof":
+		allowed := strings.Fields(argument)
+		if len(allowed) == 0 {
+			return nil, fmt.Errorf("oneof expects a space separated list of values")
+		}
+		message := "must be one of: " + strings.Join(allowed, ", ")
+		if elemType.Kind() == reflect.String {
+			return deref(func(value reflect.Value) string {
+				for _, candidate := range allowed {
+					if value.String() == candidate {
+						return ""
+					}
+				}
+				return message
+			}), nil
+		}
+		if measure := numericMeasure(elemType); measure != nil {
+			allowedNumbers := make([]float64, len(allowed))
+			for i, candidate := range allowed {
+				number, err := strconv.ParseFloat(candidate, 64)
+				if err != nil {
+					return nil, fmt.Errorf("oneof expects numbers for %s, got %q", fieldType, candidate)
+				}
+				allowedNumbers[i] = number
+			}
+			return deref(func(value reflect.Value) string {
+				for _, candidate := range allowedNumbers {
+					if measure(value) == candidate {
+						return ""
+					}
+				}
+				return message
+			}), nil
+		}
+		return nil, fmt.Errorf("oneof does not apply to %s", fieldType)
+	}
+	return nil, fmt.Errorf("unknown validate rule %q", option)
+}
+
+func numericMeasure(numberType reflect.Type) func(value reflect.Value) float64 {
+	switch numberType.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return func(value reflect.Value) float64 { return float64(value.Int()) }
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return func(value reflect.Value) float64 { return float64(value.Uint()) }
+	case reflect.Float32, reflect.Float64:
+		return func(value reflect.Value) float64 { return value.Float() }
+	}
+	return nil
+}
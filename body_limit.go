@@ -0,0 +1,186 @@
+package eprouter
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	RequestEntityTooLargePrefix      = "413 Request Entity Too Large"
+	RequestEntityTooLargeErrorNumber = 4130000413
+)
+
+// returned by RequestBody() once the body has been handed out via RequestBodyReader()
+var ErrRequestBodyStreamed = errors.New("eprouter: request body was already consumed via RequestBodyReader")
+
+// Wraps the request body, failing reads once more than limit bytes have been read.
+// The limit starts as Router.MaxBodyBytes (so PreProcessors are covered), and becomes the route's once it is known.
+// Nothing is lost when the limit is hit, so reading can resume if the route raises it.
+//
+// After a timeout, the handler's goroutine may still be reading while the serving goroutine fails the body,
+// so the read state is only touched w/ mutex held, and failed is atomic so that fail() never waits on a read.
+type limitedRequestBody struct {
+	body io.ReadCloser
+
+	mutex   sync.Mutex // guards limit, read and pending, held for a whole Read
+	limit   int64      // <= 0 is unlimited
+	read    int64      // bytes handed out
+	pending []byte     // read from body while probing past the limit, handed out first
+
+	failed atomic.Pointer[error] // set from another goroutine, see fail()
+}
+
+func (lb *limitedRequestBody) Read(p []byte) (int, error) {
+	if err := lb.failure(); err != nil {
+		return 0, err
+	}
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+	if err := lb.failure(); err != nil {
+		// failed while waiting on another read
+		return 0, err
+	}
+
+	if lb.limit > 0 {
+		remaining := lb.limit - lb.read
+		if remaining <= 0 {
+			// only too large if there is more to come
+			if len(lb.pending) == 0 {
+				probe := make([]byte, 1)
+				n, err := lb.body.Read(probe)
+				if n == 0 {
+					return 0, err
+				}
+				lb.pending = probe[:n]
+			}
+			return 0, newRequestEntityTooLargeError(lb.limit)
+		}
+		if int64(len(p)) > remaining {
+			p = p[:remaining]
+		}
+	}
+	if len(lb.pending) > 0 {
+		n := copy(p, lb.pending)
+		lb.pending = lb.pending[n:]
+		lb.read += int64(n)
+		return n, nil
+	}
+	n, err := lb.body.Read(p)
+	lb.read += int64(n)
+	return n, err
+}
+
+func (lb *limitedRequestBody) Close() error {
+	return lb.body.Close()
+}
+
+// <= 0 is unlimited
+func (lb *limitedRequestBody) setLimit(limit int64) {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+	lb.limit = limit
+}
+
+// all reads from now on fail w/ err.  eg: once a timed out handler's response was sent, the body is no longer ours to read
+// A read already in progress still completes.
+func (lb *limitedRequestBody) fail(err error) {
+	lb.failed.Store(&err)
+}
+
+func (lb *limitedRequestBody) failure() error {
+	if errPtr := lb.failed.Load(); errPtr != nil {
+		return *errPtr
+	}
+	return nil
+}
+
+func isRequestEntityTooLargeError(err error) bool {
+	rerr, isRouteError := err.(*RouteError)
+	return isRouteError && rerr.errorInfo.ErrorNumber == RequestEntityTooLargeErrorNumber
+}
+
+func newRequestEntityTooLargeError(limit int64) *RouteError {
+	return NewRouteError(http.StatusRequestEntityTooLarge, ErrorInfo{
+		ErrorNumber:  RequestEntityTooLargeErrorNumber,
+		ErrorMessage: RequestEntityTooLargePrefix,
+		DebugMessage: "request body is limited to " + strconv.FormatInt(limit, 10) + " bytes",
+	})
+}
+
+// Route.MaxBodyBytes if set, Router.MaxBodyBytes otherwise.  <= 0 is unlimited
+func (router *Router) maxBodyBytesFor(routePtr *Route) int64 {
+	if routePtr.MaxBodyBytes != 0 {
+		return routePtr.MaxBodyBytes
+	}
+	return router.MaxBodyBytes
+}
+
+// applies the route's limit, also to anything PreProcessors already read w/ the router's limit.
+// Requests which declare (or already read) a larger body are answered w/ a 413 right away.
+// returns false if a response was sent.
+func (router *Router) enforceBodyLimit(ctx *Context, routePtr *Route) bool {
+	limit := router.maxBodyBytesFor(routePtr)
+	if ctx.limitedBody != nil {
+		ctx.limitedBody.setLimit(limit)
+	}
+
+	tooLarge := limit > 0 && ctx.Req.ContentLength > limit
+	if limit > 0 && int64(len(ctx.cachedRequestBody)) > limit {
+		tooLarge = true
+	}
+	if isRequestEntityTooLargeError(ctx.cachedRequestBodyError) {
+		if limit > 0 && int64(len(ctx.partialRequestBody)) >= limit {
+			tooLarge = true
+		} else {
+			// the route allows more than the router, RequestBody() picks up where it stopped
+			ctx.cachedRequestBodyError = nil
+		}
+	}
+
+	if tooLarge {
+		rerr := newRequestEntityTooLargeError(limit)
+		sendErrorPayload(ctx, rerr.statusCode, rerr.errorInfo, "")
+		return false
+	}
+	return true
+}
+
+// Streams the request body, for handlers which process large bodies incrementally.
+// Reads past the size limit fail w/ a *RouteError (413), which the handler can return as is.
+// If the body was already read via RequestBody() (eg: by middleware peeking at it), the cached bytes are streamed instead.
+// Otherwise the body is consumed by the stream, and later calls to RequestBody() return ErrRequestBodyStreamed.
+func (ctx *Context) RequestBodyReader() io.Reader {
+	if ctx.cachedRequestBody != nil || ctx.cachedRequestBodyError != nil || ctx.Req.Body == nil {
+		return &cachedBodyReader{remaining: ctx.cachedRequestBody, err: ctx.cachedRequestBodyError}
+	}
+	ctx.cachedRequestBodyError = ErrRequestBodyStreamed
+	if len(ctx.partialRequestBody) > 0 {
+		partial := ctx.partialRequestBody
+		ctx.partialRequestBody = nil
+		return io.MultiReader(bytes.NewReader(partial), ctx.Req.Body)
+	}
+	return ctx.Req.Body
+}
+
+// like bytes.Reader, but ends w/ the error RequestBody() ended w/, if any
+type cachedBodyReader struct {
+	remaining []byte
+	err       error
+}
+
+func (cr *cachedBodyReader) Read(p []byte) (int, error) {
+	if len(cr.remaining) == 0 {
+		if cr.err != nil {
+			return 0, cr.err
+		}
+		return 0, io.EOF
+	}
+	n := copy(p, cr.remaining)
+	cr.remaining = cr.remaining[n:]
+	return n, nil
+}
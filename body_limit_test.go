@@ -0,0 +1,179 @@
+package eprouter
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/amattn/deeperror"
+)
+
+type peekingPreProcessor struct {
+	peeked []byte
+}
+
+func (pp *peekingPreProcessor) Process(ctx *Context) (terminateEarly bool, derr *deeperror.DeepError) {
+	pp.peeked, _ = ctx.RequestBody()
+	return false, nil
+}
+
+func TestRouterMaxBodyBytes(t *testing.T) {
+	router := NewRouter()
+	router.BasePath = "/api/"
+	router.MaxBodyBytes = 10
+
+	countBytes := func(ctx *Context) RouteHandlerResult {
+		count, err := io.Copy(ioutil.Discard, ctx.RequestBodyReader())
+		if err != nil {
+			return ctx.MakeRouteHandlerResultFromError(err)
+		}
+		if _, err := ctx.RequestBody(); err != ErrRequestBodyStreamed {
+			t.Error("expected ErrRequestBodyStreamed after streaming, got", err)
+		}
+		return ctx.MakeRouteHandlerResultGenericJSON(map[string]int64{"count": count})
+	}
+	router.Handle("POST", "1", "", "small", countBytes, nil)
+	router.Handle("POST", "1", "", "large", countBytes, &RouteOptions{MaxBodyBytes: 20})
+	router.Handle("POST", "1", "", "unlimited", countBytes, &RouteOptions{MaxBodyBytes: -1})
+	router.Handle("POST", "1", "", "decode", func(ctx *Context) RouteHandlerResult {
+		var v map[string]string
+		if err := ctx.DecodeRequestBody(&v); err != nil {
+			return ctx.MakeRouteHandlerResultFromError(err)
+		}
+		return ctx.MakeRouteHandlerResultGenericJSON(v)
+	}, nil)
+
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	type testCase struct {
+		urlsuffix          string
+		body               string
+		chunked            bool // no Content-Length, so the limit is only noticed while reading
+		expectedStatusCode int
+		expectedCount      int64
+	}
+	testCases := []testCase{
+		{"/api/v1/small", "0123456789", false, http.StatusOK, 10},
+		{"/api/v1/small", "0123456789a", false, http.StatusRequestEntityTooLarge, 0},
+		{"/api/v1/small", "0123456789a", true, http.StatusRequestEntityTooLarge, 0},
+		{"/api/v1/large", "0123456789a", true, http.StatusOK, 11},
+		{"/api/v1/large", strings.Repeat("x", 21), false, http.StatusRequestEntityTooLarge, 0},
+		{"/api/v1/unlimited", strings.Repeat("x", 1000), true, http.StatusOK, 1000},
+		{"/api/v1/decode", `{"a":"b"}`, true, http.StatusOK, 0},
+		{"/api/v1/decode", `{"a":"bcdef"}`, true, http.StatusRequestEntityTooLarge, 0},
+	}
+
+	for i, tc := range testCases {
+		var body io.Reader = strings.NewReader(tc.body)
+		if tc.chunked {
+			body = ioutil.NopCloser(body) // hides the length from http.NewRequest
+		}
+		req, _ := http.NewRequest("POST", ts.URL+tc.urlsuffix, body)
+		response, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bodyBytes, _ := ioutil.ReadAll(response.Body)
+		response.Body.Close()
+		if response.StatusCode != tc.expectedStatusCode {
+			t.Error(i, tc.urlsuffix, "expected ", tc.expectedStatusCode, ", got", response.StatusCode, string(bodyBytes))
+			continue
+		}
+		if tc.expectedStatusCode == http.StatusRequestEntityTooLarge {
+			pw := new(PayloadWrapper)
+			json.Unmarshal(bodyBytes, pw)
+			if pw.ErrorNumber != RequestEntityTooLargeErrorNumber {
+				t.Error(i, tc.urlsuffix, "expected RequestEntityTooLargeErrorNumber, got", string(bodyBytes))
+			}
+			continue
+		}
+		if tc.urlsuffix != "/api/v1/decode" && bytes.Contains(bodyBytes, []byte(`"count":`+strconv.FormatInt(tc.expectedCount, 10))) == false {
+			t.Error(i, tc.urlsuffix, "expected count", tc.expectedCount, ", got", string(bodyBytes))
+		}
+	}
+}
+
+func TestRequestBodyReaderAfterPeek(t *testing.T) {
+	router := NewRouter()
+	router.BasePath = "/api/"
+	peeker := new(peekingPreProcessor)
+	router.PreProcessors = append(router.PreProcessors, peeker)
+	router.Handle("POST", "1", "", "echo", func(ctx *Context) RouteHandlerResult {
+		streamed, err := ioutil.ReadAll(ctx.RequestBodyReader())
+		if err != nil {
+			return ctx.MakeRouteHandlerResultFromError(err)
+		}
+		return ctx.MakeRouteHandlerResultGenericJSON(map[string]string{"streamed": string(streamed)})
+	}, nil)
+
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	response, err := http.Post(ts.URL+"/api/v1/echo", "text/plain", strings.NewReader("peekaboo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bodyBytes, _ := ioutil.ReadAll(response.Body)
+	response.Body.Close()
+	if string(peeker.peeked) != "peekaboo" || strings.Contains(string(bodyBytes), `"streamed":"peekaboo"`) == false {
+		t.Error("expected both the preprocessor and the handler to see the body, got", string(peeker.peeked), string(bodyBytes))
+	}
+}
+
+func TestRouterMaxBodyBytesAfterPeek(t *testing.T) {
+	type testCase struct {
+		routerLimit, routeLimit int64
+		body                    string
+		expectedStatusCode      int
+	}
+	testCases := []testCase{
+		// the route raises the limit after the preprocessor hit the router's
+		{10, 20, "0123456789ab", http.StatusOK},
+		{10, -1, strings.Repeat("x", 100), http.StatusOK},
+		{10, 20, strings.Repeat("x", 21), http.StatusRequestEntityTooLarge},
+		// the route lowers the limit after the preprocessor read the whole body
+		{0, 2, "01234567", http.StatusRequestEntityTooLarge},
+		{10, 5, "0123456789ab", http.StatusRequestEntityTooLarge},
+		{0, 8, "01234567", http.StatusOK},
+	}
+
+	for i, tc := range testCases {
+		router := NewRouter()
+		router.BasePath = "/api/"
+		router.MaxBodyBytes = tc.routerLimit
+		router.PreProcessors = append(router.PreProcessors, new(peekingPreProcessor))
+		router.Handle("POST", "1", "", "echo", func(ctx *Context) RouteHandlerResult {
+			bodyBytes, err := ctx.RequestBody()
+			if err != nil {
+				return ctx.MakeRouteHandlerResultFromError(err)
+			}
+			return ctx.MakeRouteHandlerResultGenericJSON(map[string]string{"body": string(bodyBytes)})
+		}, &RouteOptions{MaxBodyBytes: tc.routeLimit})
+		ts := httptest.NewServer(router)
+
+		// chunked, so only reading notices the size
+		req, _ := http.NewRequest("POST", ts.URL+"/api/v1/echo", ioutil.NopCloser(strings.NewReader(tc.body)))
+		response, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bodyBytes, _ := ioutil.ReadAll(response.Body)
+		response.Body.Close()
+		ts.Close()
+
+		if response.StatusCode != tc.expectedStatusCode {
+			t.Error(i, "expected", tc.expectedStatusCode, ", got", response.StatusCode, string(bodyBytes))
+			continue
+		}
+		if tc.expectedStatusCode == http.StatusOK && strings.Contains(string(bodyBytes), `"body":"`+tc.body+`"`) == false {
+			t.Error(i, "expected the whole body, got", string(bodyBytes))
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package eprouter
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+type chapterBody struct {
+	Title string `json:"title" validate:"required"`
+	Pages int    `json:"pages" validate:"min=1"`
+}
+
+type bookBody struct {
+	Name     string        `json:"name" validate:"required,max=10"`
+	AuthorId uint64        `json:"authorId" validate:"required"`
+	Tags     []string      `json:"tags" validate:"max=2"`
+	Status   string        `json:"status" validate:"oneof=draft published"`
+	Rating   *float64      `json:"rating" validate:"min=0,max=5"`
+	Chapters []chapterBody `json:"chapters"`
+}
+
+func decodeBodyForTesting(contentType, body string, dst interface{}, options DecodeOptions) error {
+	ctx := new(Context)
+	ctx.Req = httptest.NewRequest("POST", "/api/v1/book/", bytes.NewBufferString(body))
+	if contentType != "" {
+		ctx.Req.Header.Set(HttpHeaderContentType, contentType)
+	}
+	return ctx.DecodeRequestBodyWithOptions(dst, options)
+}
+
+func TestDecodeRequestBody(t *testing.T) {
+	var book bookBody
+	err := decodeBodyForTesting("application/json; charset=utf-8", `{"name":"Café","authorId":7,"status":"draft","chapters":[{"title":"One","pages":3}]}`, &book, DecodeOptions{})
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	expected := bookBody{Name: "Café", AuthorId: 7, Status: "draft", Chapters: []chapterBody{{"One", 3}}}
+	if reflect.DeepEqual(book, expected) == false {
+		t.Errorf("expected %+v, got %+v", expected, book)
+	}
+
+	// vendor media types and a missing Content-Type are fine
+	for _, contentType := range []string{"application/vnd.collectivehealth.book.v2+json", ""} {
+		if err := decodeBodyForTesting(contentType, `{"name":"a","authorId":1,"status":"draft"}`, &bookBody{}, DecodeOptions{}); err != nil {
+			t.Error(contentType, "unexpected error", err)
+		}
+	}
+
+	// the body is cached, so it can be decoded twice
+	ctx := new(Context)
+	ctx.Req = httptest.NewRequest("POST", "/api/v1/book/", bytes.NewBufferString(`{"name":"a","authorId":1,"status":"draft"}`))
+	for i := 0; i < 2; i++ {
+		if err := ctx.DecodeRequestBody(&bookBody{}); err != nil {
+			t.Error(i, "unexpected error", err)
+		}
+	}
+}
+
+func TestDecodeRequestBodyErrors(t *testing.T) {
+	type testCase struct {
+		contentType        string
+		body               string
+		options            DecodeOptions
+		expectedStatusCode int
+		expectedErrNo      int64
+		expectedFields     []string
+	}
+	valid := `{"name":"a","authorId":1,"status":"draft"}`
+	testCases := []testCase{
+		{"text/plain", valid, DecodeOptions{}, http.StatusUnsupportedMediaType, UnsupportedMediaTypeErrorNumber, nil},
+		{"", valid, DecodeOptions{RequireContentType: true}, http.StatusUnsupportedMediaType, UnsupportedMediaTypeErrorNumber, nil},
+		{"application/json", ``, DecodeOptions{}, http.StatusBadRequest, 1702265431, nil},
+		{"application/json", `Hello World!`, DecodeOptions{}, http.StatusBadRequest, 1702265432, nil},
+		{"application/json", `{"name":"a"} trailing garbage {`, DecodeOptions{}, http.StatusBadRequest, 1702265432, nil},
+		{"application/json", `{"name":"a"}}`, DecodeOptions{}, http.StatusBadRequest, 1702265432, nil},
+		{"application/json", `{"name":"a","authorId":"seven"}`, DecodeOptions{}, http.StatusBadRequest, 1702265432, []string{"authorId"}},
+		{"application/json", `{"name":"a","authorId":1,"status":"draft","isbn":"x"}`, DecodeOptions{DisallowUnknownFields: true}, http.StatusBadRequest, 1702265432, []string{"isbn"}},
+		{"application/json", `{"name":"Much too long","tags":["a","b","c"],"status":"gone","rating":6,"chapters":[{"title":"One","pages":1},{"pages":0}]}`, DecodeOptions{}, http.StatusBadRequest, BadRequestInvalidBodyErrorNumber,
+			[]string{"name", "authorId", "tags", "status", "rating", "chapters[1].title", "chapters[1].pages"}},
+	}
+
+	for i, tc := range testCases {
+		err := decodeBodyForTesting(tc.contentType, tc.body, &bookBody{}, tc.options)
+		rerr, isRouteError := err.(*RouteError)
+		if isRouteError == false {
+			t.Error(i, "expected *RouteError, got", err)
+			continue
+		}
+		if rerr.statusCode != tc.expectedStatusCode || rerr.errorInfo.ErrorNumber != tc.expectedErrNo {
+			t.Error(i, "expected", tc.expectedStatusCode, tc.expectedErrNo, "got", rerr.statusCode, rerr.errorInfo.ErrorNumber)
+		}
+		fields := []string{}
+		for _, fieldError := range rerr.errorInfo.FieldErrors {
+			fields = append(fields, fieldError.Field)
+		}
+		if len(tc.expectedFields) > 0 && reflect.DeepEqual(fields, tc.expectedFields) == false {
+			t.Error(i, "expected field errors for", tc.expectedFields, "got", rerr.errorInfo.FieldErrors)
+		}
+	}
+
+	// malformed tags are a programmer error
+	type badTags struct {
+		Flag bool `validate:"min=1"`
+	}
+	err := decodeBodyForTesting("application/json", `{"Flag":true}`, &badTags{}, DecodeOptions{})
+	if _, isRouteError := err.(*RouteError); err == nil || isRouteError {
+		t.Error("expected a server error for malformed validate tags, got", err)
+	}
+}
+
+func TestTypedHandlerDecodeOptions(t *testing.T) {
+	type testCase struct {
+		applyToTypedHandlers bool
+		contentType, body    string
+		expectedStatusCode   int
+	}
+	testCases := []testCase{
+		{false, "text/plain", `{"Name":"New Book","AuthorId":7}`, http.StatusOK},
+		{false, "text/plain", `{"Name":"New Book"} trailing garbage {`, http.StatusBadRequest},
+		{true, "text/plain", `{"Name":"New Book","AuthorId":7}`, http.StatusUnsupportedMediaType},
+		{true, "application/json", `{"Name":"New Book","AuthorId":7}`, http.StatusOK},
+	}
+
+	for i, tc := range testCases {
+		router := NewRouter()
+		router.BasePath = "/api/"
+		router.DecodeOptions.ApplyToTypedHandlers = tc.applyToTypedHandlers
+		router.RegisterEntity("shelf", &ShelfController{})
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/api/v1/shelf", bytes.NewBufferString(tc.body))
+		req.Header.Set(HttpHeaderContentType, tc.contentType)
+		router.ServeHTTP(w, req)
+		if w.Code != tc.expectedStatusCode {
+			t.Error(i, "expected", tc.expectedStatusCode, "got", w.Code, w.Body.String())
+		}
+	}
+}
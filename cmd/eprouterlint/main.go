@@ -0,0 +1,251 @@
+// eprouterlint reports exported methods of controllers which look like eprouter handlers, but won't be routed.
+// Controllers are types named *Controller, types w/ at least one correctly named handler, or types implementing
+// one of the optional controller interfaces (eg: AuthHandler, PrimaryKeyParser).
+//
+// Usage:
+//
+//	eprouterlint [dir | dir/... ...]
+//
+// dir/... also lints every package below dir (skipping testdata, vendor, and directories starting w/ . or _).
+// Output is in the same file:line:col: message format as go vet.  Exits with status 1 if any issues are found.
+//
+// This is a static check (go/ast only), so handler signatures are matched by name.
+// For a full check (including AuthHandler implementation), use eprouter.LintControllersForTesting in a unit test.
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/collectivehealth/eprouter"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// returns the exit status
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		args = []string{"."}
+	}
+
+	issueCount := 0
+	for _, arg := range args {
+		dirs := []string{arg}
+		if arg == "..." || strings.HasSuffix(arg, "/...") {
+			root := strings.TrimSuffix(strings.TrimSuffix(arg, "..."), "/")
+			if root == "" {
+				root = "."
+			}
+			var err error
+			dirs, err = packageDirs(root)
+			if err != nil {
+				fmt.Fprintln(stderr, "eprouterlint:", err)
+				return 2
+			}
+		}
+		for _, dir := range dirs {
+			count, err := lintDir(dir, stdout)
+			if err != nil {
+				fmt.Fprintln(stderr, "eprouterlint:", err)
+				return 2
+			}
+			issueCount += count
+		}
+	}
+
+	if issueCount > 0 {
+		return 1
+	}
+	return 0
+}
+
+// root and every directory below it, skipped the same way the go tool skips them
+func packageDirs(root string) ([]string, error) {
+	dirs := []string{}
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() == false {
+			return nil
+		}
+		name := entry.Name()
+		if path != root && (name == "testdata" || name == "vendor" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")) {
+			return filepath.SkipDir
+		}
+		dirs = append(dirs, path)
+		return nil
+	})
+	return dirs, err
+}
+
+func lintDir(dir string, stdout io.Writer) (int, error) {
+	fset := token.NewFileSet()
+	notTests := func(info os.FileInfo) bool {
+		return strings.HasSuffix(info.Name(), "_test.go") == false
+	}
+	pkgs, err := parser.ParseDir(fset, dir, notTests, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	issueCount := 0
+	for _, pkg := range pkgs {
+		methods := []*ast.FuncDecl{}
+		for _, file := range pkg.Files {
+			for _, decl := range file.Decls {
+				funcDecl, isFunc := decl.(*ast.FuncDecl)
+				if isFunc && funcDecl.Recv != nil && funcDecl.Name.IsExported() {
+					methods = append(methods, funcDecl)
+				}
+			}
+		}
+
+		controllers := controllerTypes(methods)
+		for _, funcDecl := range methods {
+			name := funcDecl.Name.Name
+			if controllers[receiverTypeName(funcDecl)] == false || eprouter.LooksLikeHandlerName(name) == false {
+				continue
+			}
+
+			reason := ""
+			if isValid, nameReason := eprouter.LintHandlerName(name); isValid == false {
+				reason = nameReason
+			} else if isValid, signatureReason := validSignature(funcDecl.Type); isValid == false {
+				reason = signatureReason
+			}
+			if reason != "" {
+				fmt.Fprintf(stdout, "%s: %s: %s\n", fset.Position(funcDecl.Pos()), name, reason)
+				issueCount++
+			}
+		}
+	}
+	return issueCount, nil
+}
+
+// methods only found on controllers, see the optional interfaces in package eprouter
+var controllerMethodNames = map[string]bool{
+	"PerformAuth":       true,
+	"ParsePrimaryKey":   true,
+	"RouteTimeout":      true,
+	"RouteMaxBodyBytes": true,
+	"RouteMetadata":     true,
+}
+
+// Only controllers are linted, otherwise methods like Context.MakeRouteHandlerResult would be reported.
+// A type is a controller if it is named like one (eg: BookController), has a correctly named handler,
+// or implements any of the optional controller interfaces.
+func controllerTypes(methods []*ast.FuncDecl) map[string]bool {
+	controllers := map[string]bool{}
+	for _, funcDecl := range methods {
+		typeName := receiverTypeName(funcDecl)
+		name := funcDecl.Name.Name
+		isValidHandler := false
+		if eprouter.LooksLikeHandlerName(name) {
+			isValidHandler, _ = eprouter.LintHandlerName(name)
+		}
+		if strings.HasSuffix(typeName, "Controller") || isValidHandler || controllerMethodNames[name] {
+			controllers[typeName] = true
+		}
+	}
+	return controllers
+}
+
+// eg: BookController for (bc *BookController), or Cache for (c *Cache[K, V])
+func receiverTypeName(funcDecl *ast.FuncDecl) string {
+	expr := funcDecl.Recv.List[0].Type
+	for {
+		switch typed := expr.(type) {
+		case *ast.StarExpr:
+			expr = typed.X
+		case *ast.IndexExpr:
+			expr = typed.X
+		case *ast.IndexListExpr:
+			expr = typed.X
+		case *ast.ParenExpr:
+			expr = typed.X
+		case *ast.Ident:
+			return typed.Name
+		default:
+			return ""
+		}
+	}
+}
+
+// Mirrors eprouter.ValidateHandler:
+//
+//	func(*Context[, *T]) RouteHandlerResult
+//	func(*Context[, *T]) (Payload, error)
+//	func(*Context[, *T]) ([]Payload, error)
+func validSignature(funcType *ast.FuncType) (bool, string) {
+	const wrongTypeReason = "wrong function type, expected function type of RouteHandler, func(*Context[, *T]) (Payload, error) or func(*Context[, *T]) ([]Payload, error)"
+
+	params := flattenFields(funcType.Params)
+	if len(params) < 1 || len(params) > 2 {
+		return false, wrongTypeReason
+	}
+	if star, isStar := params[0].(*ast.StarExpr); isStar == false || typeName(star.X) != "Context" {
+		return false, wrongTypeReason
+	}
+	if len(params) == 2 {
+		if _, isStar := params[1].(*ast.StarExpr); isStar == false {
+			return false, "wrong function type, request body parameter must be a pointer"
+		}
+	}
+
+	results := flattenFields(funcType.Results)
+	switch len(results) {
+	case 1:
+		if typeName(results[0]) == "RouteHandlerResult" {
+			return true, ""
+		}
+	case 2:
+		if typeName(results[1]) != "error" {
+			break
+		}
+		if typeName(results[0]) == "Payload" {
+			return true, ""
+		}
+		if array, isArray := results[0].(*ast.ArrayType); isArray && array.Len == nil && typeName(array.Elt) == "Payload" {
+			return true, ""
+		}
+	}
+	return false, wrongTypeReason
+}
+
+func flattenFields(fieldList *ast.FieldList) []ast.Expr {
+	exprs := []ast.Expr{}
+	if fieldList == nil {
+		return exprs
+	}
+	for _, field := range fieldList.List {
+		count := len(field.Names)
+		if count == 0 {
+			count = 1
+		}
+		for i := 0; i < count; i++ {
+			exprs = append(exprs, field.Type)
+		}
+	}
+	return exprs
+}
+
+// Context or eprouter.Context both return "Context"
+func typeName(expr ast.Expr) string {
+	switch typed := expr.(type) {
+	case *ast.Ident:
+		return typed.Name
+	case *ast.SelectorExpr:
+		return typed.Sel.Name
+	}
+	return ""
+}
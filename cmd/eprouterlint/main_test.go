@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const validControllerSource = `package controllers
+
+import "github.com/collectivehealth/eprouter"
+
+type BookController struct{}
+
+func (bc *BookController) GetHandlerV1(ctx *eprouter.Context) eprouter.RouteHandlerResult {
+	return ctx.MakeRouteHandlerResultOk()
+}
+func (bc *BookController) PostHandlerV1(ctx *eprouter.Context, book *Book) (eprouter.Payload, error) {
+	return nil, nil
+}
+`
+
+const nearMissControllerSource = `package promos
+
+import "github.com/collectivehealth/eprouter"
+
+type PromoController struct{}
+
+func (pc *PromoController) FetchHandlerV1(ctx *eprouter.Context) eprouter.RouteHandlerResult {
+	return ctx.MakeRouteHandlerResultOk()
+}
+func (pc *PromoController) GetHandlerV1(ctx *eprouter.Context) string {
+	return ""
+}
+
+// not a controller, so never linted
+type Response struct{}
+
+func (r *Response) WriteHandlerResult() {}
+func (r *Response) Gethandler() {}
+`
+
+func writeFileForTesting(t *testing.T, path, source string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRun(t *testing.T) {
+	root := t.TempDir()
+	writeFileForTesting(t, filepath.Join(root, "controllers", "book.go"), validControllerSource)
+	writeFileForTesting(t, filepath.Join(root, "controllers", "promos", "promo.go"), nearMissControllerSource)
+	// never linted
+	writeFileForTesting(t, filepath.Join(root, "controllers", "promos", "promo_test.go"), strings.Replace(nearMissControllerSource, "PromoController", "TestController", -1))
+	writeFileForTesting(t, filepath.Join(root, "controllers", "testdata", "fixture.go"), nearMissControllerSource)
+	writeFileForTesting(t, filepath.Join(root, "controllers", "vendor", "dep", "dep.go"), nearMissControllerSource)
+
+	type testCase struct {
+		args           []string
+		expectedStatus int
+		expectedIssues []string
+	}
+	testCases := []testCase{
+		{[]string{filepath.Join(root, "controllers")}, 0, nil},
+		{[]string{filepath.Join(root, "controllers", "promos")}, 1, []string{"FetchHandlerV1", "GetHandlerV1"}},
+		{[]string{filepath.Join(root, "controllers") + "/..."}, 1, []string{"FetchHandlerV1", "GetHandlerV1"}},
+		{[]string{filepath.Join(root, "missing")}, 2, nil},
+	}
+
+	for i, tc := range testCases {
+		stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+		status := run(tc.args, stdout, stderr)
+		if status != tc.expectedStatus {
+			t.Error(i, tc.args, "expected status", tc.expectedStatus, "got", status, stdout.String(), stderr.String())
+		}
+		lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+		if len(tc.expectedIssues) == 0 {
+			if stdout.Len() > 0 {
+				t.Error(i, tc.args, "expected no issues, got", stdout.String())
+			}
+			continue
+		}
+		if len(lines) != len(tc.expectedIssues) {
+			t.Error(i, tc.args, "expected", tc.expectedIssues, "got", stdout.String())
+			continue
+		}
+		for j, name := range tc.expectedIssues {
+			if strings.Contains(lines[j], filepath.Join("promos", "promo.go")+":") == false || strings.Contains(lines[j], ": "+name+": ") == false {
+				t.Error(i, tc.args, "expected an issue for", name, "got", lines[j])
+			}
+		}
+	}
+}
+
+// eprouter itself has methods like Context.MakeRouteHandlerResult and Router.HandleRoutesEndpoint, none of which are handlers
+func TestRunOnEprouter(t *testing.T) {
+	stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+	if status := run([]string{filepath.Join("..", "..") + "/..."}, stdout, stderr); status != 0 || stdout.Len() > 0 {
+		t.Error("expected no issues, got", status, stdout.String(), stderr.String())
+	}
+}
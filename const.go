@@ -3,4 +3,11 @@ package eprouter
 const (
 	HttpHeaderContentType     = "Content-Type"
 	HttpHeaderContentTypeJSON = "application/json"
+	HttpHeaderContentLength   = "Content-Length"
+	HttpHeaderAllow           = "Allow"
+	HttpHeaderDeprecation     = "Deprecation"
+	HttpHeaderSunset          = "Sunset"
+	HttpHeaderLink            = "Link"
+	HttpHeaderAccept          = "Accept"
+	HttpHeaderVary            = "Vary"
 )
@@ -1,6 +1,7 @@
 package eprouter
 
 import (
+	"context"
 	"encoding/json"
 	"io/ioutil"
 	"log"
@@ -13,19 +14,34 @@ type Context struct {
 	Req      *http.Request // original http request
 	Endpoint Endpoint      // parsed endpoint information
 
+	// The version of the handler that is serving this request.
+	// Usually the same as Endpoint.VersionStr, but may be lower when Router.VersionFallback is enabled.
+	ResolvedVersionStr string
+
 	// only populated after a call to ctx.RequestBody()
 	cachedRequestBody      []byte
 	cachedRequestBodyError error
+	limitedBody            *limitedRequestBody // wraps Req.Body, see body_limit.go
+	partialRequestBody     []byte              // read before hitting the router's limit, kept in case the route raises it
 
 	// exposing the responseWriter tends to induce bugs.  We keep this internal for now.
 	w http.ResponseWriter
 
+	// see StdContext
+	stdCtx context.Context
+
 	// router
 	router *Router
+	routes *routeTable // snapshot of the router's routes, taken when the request started
+
+	// set when the version came from the Accept header (eg: application/vnd.collectivehealth.book.v2+json)
+	negotiatedMediaType string
 
-	// generic maps for middleware to stuff arbitrary data
-	middleware map[string]interface{}
-	postware   map[string]interface{}
+	// typed values and cleanups, see ContextKey and OnCleanup.  nil until first used
+	store *contextStore
+
+	// set if the handler timed out and is still running, see runHandlerWithTimeout
+	handlerDone <-chan struct{}
 
 	// only populated after a write
 
@@ -48,6 +64,31 @@ func (ctx *Context) SetResponseHeader(key, value string) {
 	ctx.w.Header().Set(key, value)
 }
 
+// The standard library context for this request.  Cancelled when the client goes away, or when the route's Timeout expires.
+// Pass it along to anything that takes a context.Context (db calls, outgoing requests, etc.)
+func (ctx *Context) StdContext() context.Context {
+	if ctx.stdCtx != nil {
+		return ctx.stdCtx
+	}
+	if ctx.Req != nil {
+		return ctx.Req.Context()
+	}
+	return context.Background()
+}
+
+// Replaces the std context, usually w/ one derived from StdContext().  ctx.Req is updated to match.
+// eg: in middleware
+//
+//	ctx.SetStdContext(context.WithValue(ctx.StdContext(), requestIDKey, requestID))
+func (ctx *Context) SetStdContext(stdCtx context.Context) {
+	ctx.stdCtx = stdCtx
+	if ctx.Req != nil {
+		ctx.Req = ctx.Req.WithContext(stdCtx)
+	}
+}
+
+// Reads and caches the whole request body.  Bodies over the size limit (see Router.MaxBodyBytes) return a *RouteError (413).
+// For large bodies, see RequestBodyReader.
 func (ctx *Context) RequestBody() ([]byte, error) {
 	if ctx.cachedRequestBody != nil {
 		return ctx.cachedRequestBody, nil
@@ -56,8 +97,21 @@ func (ctx *Context) RequestBody() ([]byte, error) {
 		return nil, ctx.cachedRequestBodyError
 	}
 
-	ctx.cachedRequestBody, ctx.cachedRequestBodyError = ioutil.ReadAll(ctx.Req.Body)
-	return ctx.cachedRequestBody, ctx.cachedRequestBodyError
+	bodyBytes, err := ioutil.ReadAll(ctx.Req.Body)
+	if len(ctx.partialRequestBody) > 0 {
+		bodyBytes = append(ctx.partialRequestBody, bodyBytes...)
+		ctx.partialRequestBody = nil
+	}
+	if err != nil {
+		// never cache a truncated body
+		if isRequestEntityTooLargeError(err) {
+			ctx.partialRequestBody = bodyBytes
+		}
+		ctx.cachedRequestBodyError = err
+		return nil, err
+	}
+	ctx.cachedRequestBody = bodyBytes
+	return ctx.cachedRequestBody, nil
 }
 
 //  #####
@@ -105,6 +159,25 @@ func (ctx *Context) MakeRouteHandlerResultErrorInfo(code int, errInfo ErrorInfo)
 	return RouteHandlerResult{rerr, nil, nil}
 }
 
+// Converts an error into an error result.
+// *RouteError keeps its status code and ErrorInfo, *deeperror.DeepError uses its StatusCode, Num and EndUserMsg.
+// Anything else is treated as a 500 Internal Server Error.
+func (ctx *Context) MakeRouteHandlerResultFromError(err error) RouteHandlerResult {
+	switch typedErr := err.(type) {
+	case *RouteError:
+		return RouteHandlerResult{typedErr, nil, nil}
+	case *deeperror.DeepError:
+		code := http.StatusInternalServerError
+		if typedErr.StatusCode > 299 && typedErr.StatusCode < 999 {
+			code = typedErr.StatusCode
+		}
+		return ctx.MakeRouteHandlerResultError(code, typedErr.Num, typedErr.EndUserMsg)
+	}
+
+	log.Println("2840462733 handler returned error:", err)
+	return ctx.MakeRouteHandlerResultError(http.StatusInternalServerError, 2840462733, InternalServerErrorPrefix)
+}
+
 func (ctx *Context) MakeRouteHandlerResultAlert(code int, errNo int64, alert string) RouteHandlerResult {
 	return ctx.MakeRouteHandlerResultCustom(func(innerCtx *Context) {
 		sendErrorPayload(innerCtx, code, ErrorInfo{ErrorNumber: errNo}, alert)
@@ -136,7 +209,7 @@ func (ctx *Context) MakeRouteHandlerResultGenericJSON(v interface{}) RouteHandle
 	return ctx.MakeRouteHandlerResultStatusGenericJSON(http.StatusOK, v)
 }
 func (ctx *Context) MakeRouteHandlerResultStatusGenericJSON(statusCode int, v interface{}) RouteHandlerResult {
-	ctx.SetResponseHeader(HttpHeaderContentType, HttpHeaderContentTypeJSON)
+	ctx.SetResponseHeader(HttpHeaderContentType, ctx.jsonContentType(statusCode))
 	jsonBytes, err := json.Marshal(v)
 	if err != nil {
 		rerr := NewRouteError(
@@ -233,6 +306,8 @@ func (ctx *Context) SendSimpleAlertPayload(code int, errNo int64, errMsg, alert
 	sendErrorPayload(ctx, code, ErrorInfo{ErrorNumber: errNo, ErrorMessage: errMsg}, alert)
 }
 
+// Deprecated: writes the error response itself, so the handler's own result is a second write.
+// Use DecodeRequestBody, which returns the error instead.
 func (ctx *Context) DecodeResponseBodyOrSendError(pc PayloadController, payloadReference interface{}) interface{} {
 	requestBody := ctx.Req.Body
 	if requestBody == nil {
@@ -0,0 +1,132 @@
+package eprouter
+
+import (
+	"log"
+	"sync"
+)
+
+// Typed, request-scoped values, for middleware to pass data to handlers and post processors.
+// Keys are created once, at package level:
+//
+//	var RequestIDKey = eprouter.NewContextKey[string]("requestID")
+//
+//	// in a PreProcessor or MiddlewareProcessor
+//	RequestIDKey.Set(ctx, requestID)
+//
+//	// in a handler or PostProcessor
+//	requestID, exists := RequestIDKey.Get(ctx)
+//
+// Each key is unique, even if two share a name.  Storage is only allocated once a value or cleanup is added.
+
+type ContextKey[T any] struct {
+	name string
+}
+
+func NewContextKey[T any](name string) *ContextKey[T] {
+	return &ContextKey[T]{name: name}
+}
+
+func (key *ContextKey[T]) String() string {
+	return key.name
+}
+
+func (key *ContextKey[T]) Set(ctx *Context, value T) {
+	store := ctx.valueStore()
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if store.values == nil {
+		store.values = make(map[interface{}]interface{})
+	}
+	store.values[key] = value
+}
+
+// returns the zero value and false if the key was never set
+func (key *ContextKey[T]) Get(ctx *Context) (T, bool) {
+	var zero T
+	if ctx.store == nil {
+		return zero, false
+	}
+	ctx.store.mutex.Lock()
+	defer ctx.store.mutex.Unlock()
+	value, exists := ctx.store.values[key]
+	if exists == false {
+		return zero, false
+	}
+	return value.(T), true
+}
+
+// like Get, but returns defaultValue if the key was never set
+func (key *ContextKey[T]) GetOr(ctx *Context, defaultValue T) T {
+	if value, exists := key.Get(ctx); exists {
+		return value
+	}
+	return defaultValue
+}
+
+func (key *ContextKey[T]) Delete(ctx *Context) {
+	if ctx.store == nil {
+		return
+	}
+	ctx.store.mutex.Lock()
+	defer ctx.store.mutex.Unlock()
+	delete(ctx.store.values, key)
+}
+
+// Registers fn to run once the request is finished: after the response is written and all PostProcessors have run
+// (and, for a route that timed out, once its handler has returned).
+// Cleanups run in reverse order, like defer.  A panicking cleanup is logged, and doesn't stop the others.
+func (ctx *Context) OnCleanup(fn func()) {
+	store := ctx.valueStore()
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.cleanups = append(store.cleanups, fn)
+}
+
+// shared w/ the copy of the Context a Timeout handler runs on, hence the mutex
+type contextStore struct {
+	mutex    sync.Mutex
+	values   map[interface{}]interface{}
+	cleanups []func()
+}
+
+func (ctx *Context) valueStore() *contextStore {
+	if ctx.store == nil {
+		ctx.store = new(contextStore)
+	}
+	return ctx.store
+}
+
+func (ctx *Context) runCleanups() {
+	store := ctx.store
+	if store == nil {
+		return
+	}
+	if ctx.handlerDone != nil {
+		// a timed out handler may still be using whatever the cleanups release
+		handlerDone := ctx.handlerDone
+		go func() {
+			<-handlerDone
+			store.runCleanups()
+		}()
+		return
+	}
+	store.runCleanups()
+}
+
+func (store *contextStore) runCleanups() {
+	store.mutex.Lock()
+	cleanups := store.cleanups
+	store.cleanups = nil
+	store.mutex.Unlock()
+
+	for i := len(cleanups) - 1; i >= 0; i-- {
+		func() {
+			defer func() {
+				if p := recover(); p != nil {
+					log.Println("1439902157 cleanup panicked:", p)
+				}
+			}()
+			cleanups[i]()
+		}()
+	}
+}
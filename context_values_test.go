@@ -0,0 +1,137 @@
+package eprouter
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/amattn/deeperror"
+)
+
+var requestIDKey = NewContextKey[string]("requestID")
+var attemptsKey = NewContextKey[int]("attempts")
+
+type requestIDPreProcessor struct{}
+
+func (rp *requestIDPreProcessor) Process(ctx *Context) (terminateEarly bool, derr *deeperror.DeepError) {
+	requestIDKey.Set(ctx, "req-42")
+	return false, nil
+}
+
+type attemptsMiddleware struct{}
+
+func (am *attemptsMiddleware) Process(routePtr *Route, ctx *Context) (terminateEarly bool, derr *deeperror.DeepError) {
+	attemptsKey.Set(ctx, attemptsKey.GetOr(ctx, 0)+1)
+	return false, nil
+}
+
+type recordingPostProcessor struct {
+	mutex  sync.Mutex
+	events []string
+}
+
+func (rp *recordingPostProcessor) record(event string) {
+	rp.mutex.Lock()
+	defer rp.mutex.Unlock()
+	rp.events = append(rp.events, event)
+}
+
+func (rp *recordingPostProcessor) Process(ctx *Context) (terminateEarly bool, derr *deeperror.DeepError) {
+	requestID, _ := requestIDKey.Get(ctx)
+	rp.record("post:" + requestID)
+	return false, nil
+}
+
+func TestContextValues(t *testing.T) {
+	recorder := new(recordingPostProcessor)
+	router := NewRouter()
+	router.BasePath = "/api/"
+	router.PreProcessors = append(router.PreProcessors, &requestIDPreProcessor{})
+	router.MiddlewareProcessors = append(router.MiddlewareProcessors, &attemptsMiddleware{})
+	router.PostProcessors = append(router.PostProcessors, recorder)
+
+	router.Handle("GET", "1", "", "values", func(ctx *Context) RouteHandlerResult {
+		requestID, _ := requestIDKey.Get(ctx)
+		attempts, _ := attemptsKey.Get(ctx)
+		if requestID != "req-42" || attempts != 1 {
+			t.Error("expected values from the preprocessor and middleware, got", requestID, attempts)
+		}
+		if _, exists := NewContextKey[string]("requestID").Get(ctx); exists {
+			t.Error("expected keys w/ the same name to be distinct")
+		}
+		ctx.OnCleanup(func() { recorder.record("cleanup:first") })
+		ctx.OnCleanup(func() { panic("cleanup panics are logged") })
+		ctx.OnCleanup(func() { recorder.record("cleanup:second") })
+		return ctx.MakeRouteHandlerResultOk()
+	}, nil)
+
+	release := make(chan struct{})
+	router.Handle("GET", "1", "", "slow", func(ctx *Context) RouteHandlerResult {
+		ctx.OnCleanup(func() { recorder.record("cleanup:slow") })
+		<-release
+		recorder.record("handler:slow")
+		return ctx.MakeRouteHandlerResultOk()
+	}, &RouteOptions{Timeout: 10 * time.Millisecond})
+
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	response, err := http.Get(ts.URL + "/api/v1/values")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.ReadAll(response.Body)
+	response.Body.Close()
+
+	// the handler is served before post processors and cleanups run, give them a moment
+	expected := []string{"post:req-42", "cleanup:second", "cleanup:first"}
+	waitForEvents(recorder, len(expected))
+	recorder.mutex.Lock()
+	if len(recorder.events) != len(expected) {
+		t.Fatal("expected", expected, "got", recorder.events)
+	}
+	for i := range expected {
+		if recorder.events[i] != expected[i] {
+			t.Error("expected", expected, "got", recorder.events)
+			break
+		}
+	}
+	recorder.events = nil
+	recorder.mutex.Unlock()
+
+	// cleanups of a timed out handler wait for the handler to return
+	response, err = http.Get(ts.URL + "/api/v1/slow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.ReadAll(response.Body)
+	response.Body.Close()
+	if response.StatusCode != http.StatusGatewayTimeout {
+		t.Error("expected 504, got", response.StatusCode)
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+
+	expected = []string{"post:req-42", "handler:slow", "cleanup:slow"}
+	waitForEvents(recorder, len(expected))
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	if len(recorder.events) != len(expected) || recorder.events[1] != "handler:slow" || recorder.events[2] != "cleanup:slow" {
+		t.Error("expected", expected, "got", recorder.events)
+	}
+}
+
+func waitForEvents(recorder *recordingPostProcessor, count int) {
+	for i := 0; i < 100; i++ {
+		recorder.mutex.Lock()
+		done := len(recorder.events) >= count
+		recorder.mutex.Unlock()
+		if done {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
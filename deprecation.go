@@ -0,0 +1,187 @@
+package eprouter
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/amattn/deeperror"
+)
+
+const (
+	GonePrefix      = "410 Gone"
+	GoneErrorNumber = 4100000410
+)
+
+// Marks a version, entity+version, or single route as deprecated.
+// While deprecated, responses carry Deprecation, Sunset and Link headers.
+// After Sunset, requests are answered with 410 Gone (and Alert) instead of calling the handler.
+type Deprecation struct {
+	Date   time.Time // optional, when the deprecation took effect.  if zero, the Deprecation header is just "true"
+	Sunset time.Time // optional, when the route stops being served.  if zero, the route is served indefinitely
+	Link   string    // optional, URL of the replacement.  sent as Link: <Link>; rel="successor-version"
+	Alert  string    // optional, the Alert sent w/ 410 responses.  defaults to a generic message
+}
+
+// true once Sunset has passed
+func (deprecation *Deprecation) isSunset(now time.Time) bool {
+	return deprecation.Sunset.IsZero() == false && now.Before(deprecation.Sunset) == false
+}
+
+// Deprecation: @<unix seconds>, Sunset: <HTTP-date> and Link: <url>; rel="successor-version"
+func (deprecation *Deprecation) setHeaders(header http.Header) {
+	if deprecation.Date.IsZero() {
+		header.Set(HttpHeaderDeprecation, "true")
+	} else {
+		header.Set(HttpHeaderDeprecation, "@"+strconv.FormatInt(deprecation.Date.Unix(), 10))
+	}
+	if deprecation.Sunset.IsZero() == false {
+		header.Set(HttpHeaderSunset, deprecation.Sunset.UTC().Format(http.TimeFormat))
+	}
+	if deprecation.Link != "" {
+		header.Add(HttpHeaderLink, "<"+deprecation.Link+">; rel=\"successor-version\"")
+	}
+}
+
+func (deprecation *Deprecation) goneAlert() string {
+	if deprecation.Alert != "" {
+		return deprecation.Alert
+	}
+	alert := "This API version was retired on " + deprecation.Sunset.UTC().Format(http.TimeFormat)
+	if deprecation.Link != "" {
+		alert += ", please use " + deprecation.Link
+	}
+	return alert
+}
+
+// eg: DeprecateVersion("1", ...) applies to every route served under /v1/, including routes registered later.
+func (router *Router) DeprecateVersion(version string, deprecation Deprecation) {
+	if err := router.TryDeprecateVersion(version, deprecation); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// Like DeprecateVersion, but returns a *RegistrationError instead of calling log.Fatalln
+func (router *Router) TryDeprecateVersion(version string, deprecation Deprecation) error {
+	return router.trySetDeprecation(version, "", deprecation)
+}
+
+// eg: DeprecateEntity("1", "author/book", ...) applies to every route of the (nested) entity served under /v1/
+// Removing the entity (see RemoveEntity) also removes its deprecations.
+func (router *Router) DeprecateEntity(version, entity string, deprecation Deprecation) {
+	if err := router.TryDeprecateEntity(version, entity, deprecation); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// Like DeprecateEntity, but returns a *RegistrationError instead of calling log.Fatalln
+func (router *Router) TryDeprecateEntity(version, entity string, deprecation Deprecation) error {
+	return router.trySetDeprecation(version, strings.Trim(entity, "/"), deprecation)
+}
+
+// Deprecates a single, already registered route.  Arguments are the same as Router.Handle.
+// To deprecate an explicit route at registration, use RouteOptions.Deprecation instead.
+func (router *Router) DeprecateRoute(method, version, entity, action string, deprecation Deprecation) {
+	if err := router.TryDeprecateRoute(method, version, entity, action, deprecation); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// Like DeprecateRoute, but returns a *RegistrationError instead of calling log.Fatalln
+func (router *Router) TryDeprecateRoute(method, version, entity, action string, deprecation Deprecation) error {
+	router.lockRoutes()
+	defer router.unlockRoutes()
+
+	entity = strings.Trim(entity, "/")
+	versionStr := strings.TrimLeft(strings.TrimLeft(version, "vV"), "0")
+	rk := routeKey(strings.ToUpper(method), versionStr, entity, strings.ToLower(action))
+
+	// both the item and collection routes, if there are both (eg: GetHandlerV1 and ListHandlerV1)
+	deprecatedCount := 0
+	for _, candidateKey := range []string{rk, rk + ROUTE_MAP_SEPARATOR + ROUTE_MAP_COLLECTION_SUFFIX} {
+		routePtr, exists := router.working.routeMap[candidateKey]
+		if exists == false {
+			continue
+		}
+		// published routes are never modified, replace it w/ a deprecated copy instead
+		deprecatedRoute := *routePtr
+		deprecatedRoute.Deprecation = &deprecation
+		router.putWorkingRoute(candidateKey, &deprecatedRoute)
+		deprecatedCount++
+	}
+	if deprecatedCount == 0 {
+		regErr := newRegistrationError(entity, "")
+		regErr.add(deeperror.New(3308215947, fmt.Sprint("cannot deprecate unknown route: ", method, " ", version, " ", entity, " ", action), nil))
+		return regErr
+	}
+	return nil
+}
+
+func (router *Router) trySetDeprecation(version, entityPath string, deprecation Deprecation) error {
+	versionUint, derr := parseDeprecationVersion(version)
+	if derr != nil {
+		regErr := newRegistrationError(entityPath, "")
+		regErr.add(derr)
+		return regErr
+	}
+
+	router.lockRoutes()
+	defer router.unlockRoutes()
+	router.working.deprecations[deprecationScopeKey(versionUint, entityPath)] = &deprecation
+	return nil
+}
+
+// most specific wins: the route itself, then entity+version, then version.
+// version is the requested version, which may differ from the route's when falling back.
+func (table *routeTable) deprecationFor(routePtr *Route, version VersionUint) *Deprecation {
+	if routePtr.Deprecation != nil {
+		return routePtr.Deprecation
+	}
+	if len(table.deprecations) == 0 {
+		return nil
+	}
+	if deprecation, exists := table.deprecations[deprecationScopeKey(version, routePtr.EntityPath())]; exists && routePtr.EntityPath() != "" {
+		return deprecation
+	}
+	return table.deprecations[deprecationScopeKey(version, "")]
+}
+
+// Adds the deprecation headers.  returns true if the route is past its sunset and a 410 has been sent.
+func (router *Router) handleDeprecation(ctx *Context, routePtr *Route) (wasSunset bool) {
+	deprecation := ctx.routes.deprecationFor(routePtr, ctx.Endpoint.Version())
+	if deprecation == nil {
+		return false
+	}
+	deprecation.setHeaders(ctx.w.Header())
+	if deprecation.isSunset(time.Now()) {
+		ctx.SendSimpleAlertPayload(http.StatusGone, GoneErrorNumber, GonePrefix, deprecation.goneAlert())
+		return true
+	}
+	return false
+}
+
+// unversioned is version 0
+func deprecationScopeKey(version VersionUint, entityPath string) string {
+	return fmt.Sprint(version, ROUTE_MAP_SEPARATOR, strings.ToLower(entityPath))
+}
+
+// the entity path of a deprecationScopeKey, empty for whole versions
+func deprecationScopeEntityPath(scopeKey string) string {
+	components := strings.SplitN(scopeKey, ROUTE_MAP_SEPARATOR, 2)
+	return components[len(components)-1]
+}
+
+func parseDeprecationVersion(version string) (VersionUint, *deeperror.DeepError) {
+	versionStr := strings.TrimLeft(strings.TrimLeft(version, "vV"), "0")
+	if version == "" {
+		return 0, nil
+	}
+	v64, err := strconv.ParseUint(versionStr, 10, VERSION_BIT_DEPTH)
+	if err != nil || v64 == 0 {
+		return 0, deeperror.New(3308215946, fmt.Sprint("cannot deprecate invalid version:'", version, "'"), err)
+	}
+	return VersionUint(v64), nil
+}
@@ -18,9 +18,43 @@ type Endpoint struct {
 	Components []string
 	Extras     []string
 
+	// only populated for nested entities. eg: /v1/author/7/book/3 has Parents of [{author 7}]
+	// outermost first
+	Parents []ParentEntity
+
 	// internal only
-	version        VersionUint
-	versionConvErr error
+	version         VersionUint
+	versionConvErr  error
+	entityIndex     int         // index of EntityName in Components. 1 for versioned paths, 0 for unversioned paths
+	typedPrimaryKey interface{} // see TypedPrimaryKey
+}
+
+// An enclosing entity of a nested endpoint
+type ParentEntity struct {
+	EntityName string
+	PrimaryKey string
+}
+
+// eg: "author/book" for /v1/author/7/book/3, just "book" for /v1/book/3
+func (e *Endpoint) EntityPath() string {
+	if len(e.Parents) == 0 {
+		return e.EntityName
+	}
+	path := ""
+	for _, parent := range e.Parents {
+		path += parent.EntityName + "/"
+	}
+	return path + e.EntityName
+}
+
+// returns the primary key of the enclosing entity with the given name, or "" if there is no such parent
+func (e *Endpoint) ParentKey(entityName string) string {
+	for _, parent := range e.Parents {
+		if strings.EqualFold(parent.EntityName, entityName) {
+			return parent.PrimaryKey
+		}
+	}
+	return ""
 }
 
 // return a typed number, not a string
@@ -34,6 +68,13 @@ func (e *Endpoint) Version() VersionUint {
 	return e.version
 }
 
+// resets the cached version too
+func (e *Endpoint) setVersionStr(versionStr string) {
+	e.VersionStr = versionStr
+	e.version = 0
+	e.versionConvErr = nil
+}
+
 // exported for other packages to be able to unit test.
 func ParsePathForTesting(urlPtr *url.URL, prefix string) (endpoint Endpoint, err error) {
 	endpoint, clientErr, serverErr := parsePath(urlPtr, prefix)
@@ -48,8 +89,10 @@ func ParsePathForTesting(urlPtr *url.URL, prefix string) (endpoint Endpoint, err
 	return endpoint, err
 }
 
+// Works from the escaped path, and decodes each component individually,
+// so that an encoded slash (eg: /v1/document/a%2Fb) stays within its component.
 func parsePath(urlPtr *url.URL, prefix string) (endpoint Endpoint, clientErr, serverErr *deeperror.DeepError) {
-	urlPath := strings.Trim(urlPtr.Path, "/")
+	urlPath := strings.Trim(urlPtr.EscapedPath(), "/")
 	prefix = strings.TrimLeft(prefix, "/")
 
 	if strings.HasPrefix(urlPath, prefix) == false {
@@ -61,40 +104,120 @@ func parsePath(urlPtr *url.URL, prefix string) (endpoint Endpoint, clientErr, se
 
 	pathComponents := strings.Split(urlPath, "/")
 	pathComponentsLen := len(pathComponents)
+	for i, escapedComponent := range pathComponents {
+		component, err := url.PathUnescape(escapedComponent)
+		if err != nil {
+			return Endpoint{}, deeperror.NewHTTPError(3475081073, "Cannot parse endpoint path, invalid escape sequence", err, http.StatusBadRequest), nil
+		}
+		pathComponents[i] = component
+	}
 
-	// basic validation: should have at least a version and an entity
-	if pathComponentsLen < 2 {
+	// basic validation: should have at least a version or an entity
+	if urlPath == "" {
 		return Endpoint{}, deeperror.NewHTTPError(3475081072, "Cannot parse endpoint path, insufficent number of path components", nil, http.StatusNotFound), nil
 	}
 
 	// parse version
-	if pathComponentsLen >= 1 {
+	// paths w/o a leading v<#> component are unversioned, eg: /healthz or /status/1
+	entityIndex := 0
+	if isVersionComponent(pathComponents[0]) {
 		s := strings.TrimLeft(pathComponents[0], "vV")
 		s = strings.TrimLeft(s, "0")
 		endpoint.VersionStr = s
+		entityIndex = 1
 	}
+	endpoint.entityIndex = entityIndex
 
 	// parse entity
-	if pathComponentsLen >= 2 {
-		endpoint.EntityName = pathComponents[1]
+	// may be empty for entity-less routes, eg: /v1/
+	if pathComponentsLen > entityIndex {
+		endpoint.EntityName = pathComponents[entityIndex]
 	}
 
 	// parse pk and extra
-	if pathComponentsLen >= 3 {
+	if pathComponentsLen > entityIndex+1 {
 		//		pkey, err := strconv.ParseInt(pkeyOrActionString, 10, 64)
-		endpoint.PrimaryKey = pathComponents[2]
+		endpoint.PrimaryKey = pathComponents[entityIndex+1]
 
 		// parse extras
 		// TODO: why is extras 2+ and not everything after action?
-		endpoint.Extras = pathComponents[2:]
+		endpoint.Extras = pathComponents[entityIndex+1:]
 	}
 
-	// TODO: action is more like "subEntity"
-	if pathComponentsLen >= 4 {
-		endpoint.Action = pathComponents[3]
+	// action may turn out to be a child entity, see nestEndpoint
+	if pathComponentsLen > entityIndex+2 {
+		endpoint.Action = pathComponents[entityIndex+2]
 	}
 
 	endpoint.Components = pathComponents
 
 	return
 }
+
+// v<#> or V<#>
+func isVersionComponent(component string) bool {
+	if len(component) < 2 || (component[0] != 'v' && component[0] != 'V') {
+		return false
+	}
+	for i := 1; i < len(component); i++ {
+		if component[i] < '0' || component[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Reinterprets an entity endpoint as an entity-less one, where the entity position is actually the action.
+// eg: /v1/status/extra is EntityName:status PrimaryKey:extra, but as a root endpoint, it is Action:status Extras:[extra]
+func (e *Endpoint) rootEndpoint() Endpoint {
+	root := *e
+	root.EntityName = ""
+	root.PrimaryKey = ""
+	root.Action = e.EntityName
+	root.Extras = nil
+	if len(e.Components) > e.entityIndex+1 {
+		root.Extras = e.Components[e.entityIndex+1:]
+	}
+	return root
+}
+
+// Walks down the path components, moving any registered child entities out of the Action position.
+// children is keyed by lowercased parent entity path
+// eg: /v1/author/7/book/3/popular becomes Parents:[{author 7}] EntityName:book PrimaryKey:3 Action:popular
+func nestEndpoint(endpoint *Endpoint, children map[string]map[string]bool) {
+	if len(endpoint.Components) < endpoint.entityIndex+3 {
+		return
+	}
+
+	entityIndex := endpoint.entityIndex
+	entityPath := strings.ToLower(endpoint.EntityName)
+	for entityIndex+2 < len(endpoint.Components) {
+		childName := endpoint.Components[entityIndex+2]
+		if children[entityPath][strings.ToLower(childName)] == false {
+			break
+		}
+		endpoint.Parents = append(endpoint.Parents, ParentEntity{
+			EntityName: endpoint.Components[entityIndex],
+			PrimaryKey: endpoint.Components[entityIndex+1],
+		})
+		entityIndex += 2
+		entityPath += "/" + strings.ToLower(childName)
+	}
+
+	if entityIndex == endpoint.entityIndex {
+		return
+	}
+
+	remaining := endpoint.Components[entityIndex:]
+	endpoint.EntityName = remaining[0]
+	endpoint.PrimaryKey = ""
+	endpoint.Action = ""
+	endpoint.Extras = nil
+	if len(remaining) >= 2 {
+		endpoint.PrimaryKey = remaining[1]
+		endpoint.Extras = remaining[1:]
+	}
+	if len(remaining) >= 3 {
+		endpoint.Action = remaining[2]
+	}
+}
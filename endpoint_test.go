@@ -27,26 +27,55 @@ func TestParsePathValid(t *testing.T) {
 		"/api/v1/entity/123?a=b&c=d",
 		"/api/v1/entity?a=b&c=d",
 		"/api/v1/entity/?a=b&c=d",
+
+		"/api/entity/123",
+		"/api/healthz",
+		"/api/V007/entity/",
+
+		// percent-encoded
+		"/api/v1/document/a%2Fb",
+		"/api/v1/document/a%2Fb/action",
+		"/api/v1/document/a%2fb%2Fc/",
+		"/api/v1/document/hello%20world",
+		"/api/v1/document/caf%C3%A9",
+		"/api/v1/document/café/action",
+		"/api/v1/document/100%25",
+		"/api/v1/document/a+b",
+		"/api/v1/do%63ument/1",
 	}
 	expecteds := []Endpoint{
-		Endpoint{"1", "entity", "", "", []string{}, 0, nil},
-		Endpoint{"1", "entity", "someid", "", []string{"someid"}, 0, nil},
-
-		Endpoint{"1", "entity", "", "", []string{}, 0, nil},
-		Endpoint{"2", "entity", "", "", []string{}, 0, nil},
-		Endpoint{"3", "entity", "", "", []string{}, 0, nil},
-
-		Endpoint{"1", "entity", "someid", "", []string{"someid"}, 0, nil},
-		Endpoint{"1", "entity", "someid", "", []string{"someid"}, 0, nil},
-		Endpoint{"1", "entity", "123", "", []string{"123"}, 0, nil},
-		Endpoint{"1", "entity", "123", "action", []string{"123", "action"}, 0, nil},
-		Endpoint{"1", "entity", "123", "action", []string{"123", "action", "extra1"}, 0, nil},
-
-		Endpoint{"1", "entity", "123", "action", []string{"123", "action", "extra1"}, 0, nil},
-		Endpoint{"1", "entity", "123", "action", []string{"123", "action"}, 0, nil},
-		Endpoint{"1", "entity", "123", "", []string{"123"}, 0, nil},
-		Endpoint{"1", "entity", "", "", []string{}, 0, nil},
-		Endpoint{"1", "entity", "", "", []string{}, 0, nil},
+		Endpoint{VersionStr: "1", EntityName: "entity", PrimaryKey: "", Action: "", Extras: []string{}},
+		Endpoint{VersionStr: "1", EntityName: "entity", PrimaryKey: "someid", Action: "", Extras: []string{"someid"}},
+
+		Endpoint{VersionStr: "1", EntityName: "entity", PrimaryKey: "", Action: "", Extras: []string{}},
+		Endpoint{VersionStr: "2", EntityName: "entity", PrimaryKey: "", Action: "", Extras: []string{}},
+		Endpoint{VersionStr: "3", EntityName: "entity", PrimaryKey: "", Action: "", Extras: []string{}},
+
+		Endpoint{VersionStr: "1", EntityName: "entity", PrimaryKey: "someid", Action: "", Extras: []string{"someid"}},
+		Endpoint{VersionStr: "1", EntityName: "entity", PrimaryKey: "someid", Action: "", Extras: []string{"someid"}},
+		Endpoint{VersionStr: "1", EntityName: "entity", PrimaryKey: "123", Action: "", Extras: []string{"123"}},
+		Endpoint{VersionStr: "1", EntityName: "entity", PrimaryKey: "123", Action: "action", Extras: []string{"123", "action"}},
+		Endpoint{VersionStr: "1", EntityName: "entity", PrimaryKey: "123", Action: "action", Extras: []string{"123", "action", "extra1"}},
+
+		Endpoint{VersionStr: "1", EntityName: "entity", PrimaryKey: "123", Action: "action", Extras: []string{"123", "action", "extra1"}},
+		Endpoint{VersionStr: "1", EntityName: "entity", PrimaryKey: "123", Action: "action", Extras: []string{"123", "action"}},
+		Endpoint{VersionStr: "1", EntityName: "entity", PrimaryKey: "123", Action: "", Extras: []string{"123"}},
+		Endpoint{VersionStr: "1", EntityName: "entity", PrimaryKey: "", Action: "", Extras: []string{}},
+		Endpoint{VersionStr: "1", EntityName: "entity", PrimaryKey: "", Action: "", Extras: []string{}},
+
+		Endpoint{VersionStr: "", EntityName: "entity", PrimaryKey: "123", Action: "", Extras: []string{"123"}},
+		Endpoint{VersionStr: "", EntityName: "healthz", PrimaryKey: "", Action: "", Extras: []string{}},
+		Endpoint{VersionStr: "7", EntityName: "entity", PrimaryKey: "", Action: "", Extras: []string{}},
+
+		Endpoint{VersionStr: "1", EntityName: "document", PrimaryKey: "a/b", Action: "", Extras: []string{"a/b"}, Components: []string{"v1", "document", "a/b"}},
+		Endpoint{VersionStr: "1", EntityName: "document", PrimaryKey: "a/b", Action: "action", Extras: []string{"a/b", "action"}, Components: []string{"v1", "document", "a/b", "action"}},
+		Endpoint{VersionStr: "1", EntityName: "document", PrimaryKey: "a/b/c", Action: "", Extras: []string{"a/b/c"}, Components: []string{"v1", "document", "a/b/c"}},
+		Endpoint{VersionStr: "1", EntityName: "document", PrimaryKey: "hello world", Action: "", Extras: []string{"hello world"}, Components: []string{"v1", "document", "hello world"}},
+		Endpoint{VersionStr: "1", EntityName: "document", PrimaryKey: "café", Action: "", Extras: []string{"café"}, Components: []string{"v1", "document", "café"}},
+		Endpoint{VersionStr: "1", EntityName: "document", PrimaryKey: "café", Action: "action", Extras: []string{"café", "action"}, Components: []string{"v1", "document", "café", "action"}},
+		Endpoint{VersionStr: "1", EntityName: "document", PrimaryKey: "100%", Action: "", Extras: []string{"100%"}, Components: []string{"v1", "document", "100%"}},
+		Endpoint{VersionStr: "1", EntityName: "document", PrimaryKey: "a+b", Action: "", Extras: []string{"a+b"}, Components: []string{"v1", "document", "a+b"}}, // + is only a space in query strings
+		Endpoint{VersionStr: "1", EntityName: "document", PrimaryKey: "1", Action: "", Extras: []string{"1"}, Components: []string{"v1", "document", "1"}},
 	}
 
 	// sanity check
@@ -117,5 +146,66 @@ func (endpointPtr *Endpoint) isEqual(otherPtr *Endpoint) bool {
 		return false
 	}
 
+	// components, only if the expected endpoint specifies them
+	if otherPtr.Components != nil && stringSlicesAreEqual(endpointPtr.Components, otherPtr.Components) == false {
+		return false
+	}
+
+	// parents
+	if len(endpointPtr.Parents) != len(otherPtr.Parents) {
+		return false
+	}
+	for i := 0; i < len(endpointPtr.Parents); i++ {
+		if endpointPtr.Parents[i] != otherPtr.Parents[i] {
+			return false
+		}
+	}
+
 	return true
 }
+
+func TestNestEndpoint(t *testing.T) {
+	children := map[string]map[string]bool{
+		"author":      map[string]bool{"book": true},
+		"author/book": map[string]bool{"page": true},
+	}
+
+	inputs := []string{
+		"/api/v1/author/7",
+		"/api/v1/author/7/popular",
+		"/api/v1/author/7/book",
+		"/api/v1/author/7/book/3",
+		"/api/v1/author/7/book/3/popular",
+		"/api/v1/author/7/book/3/page/2",
+		"/api/v1/book/3/page/2",
+	}
+	expecteds := []Endpoint{
+		Endpoint{VersionStr: "1", EntityName: "author", PrimaryKey: "7", Extras: []string{"7"}},
+		Endpoint{VersionStr: "1", EntityName: "author", PrimaryKey: "7", Action: "popular", Extras: []string{"7", "popular"}},
+		Endpoint{VersionStr: "1", EntityName: "book", Parents: []ParentEntity{{"author", "7"}}},
+		Endpoint{VersionStr: "1", EntityName: "book", PrimaryKey: "3", Extras: []string{"3"}, Parents: []ParentEntity{{"author", "7"}}},
+		Endpoint{VersionStr: "1", EntityName: "book", PrimaryKey: "3", Action: "popular", Extras: []string{"3", "popular"}, Parents: []ParentEntity{{"author", "7"}}},
+		Endpoint{VersionStr: "1", EntityName: "page", PrimaryKey: "2", Extras: []string{"2"}, Parents: []ParentEntity{{"author", "7"}, {"book", "3"}}},
+		Endpoint{VersionStr: "1", EntityName: "book", PrimaryKey: "3", Action: "page", Extras: []string{"3", "page", "2"}},
+	}
+
+	for i := 0; i < len(inputs); i++ {
+		parsedURL, _ := url.Parse(inputs[i])
+		candidate, clientErr, serverErr := parsePath(parsedURL, "/api/")
+		if clientErr != nil || serverErr != nil {
+			t.Fatal("index:", i, "unexpected parsePath error", clientErr, serverErr)
+		}
+		nestEndpoint(&candidate, children)
+		if candidate.isEqual(&expecteds[i]) == false || candidate.Action != expecteds[i].Action {
+			t.Errorf("index:%d, input:%s\ncandidate: %+v \n expected: %+v", i, inputs[i], candidate, expecteds[i])
+		}
+	}
+
+	endpoint := expecteds[5]
+	if endpoint.EntityPath() != "author/book/page" {
+		t.Error("expected EntityPath author/book/page, got", endpoint.EntityPath())
+	}
+	if endpoint.ParentKey("book") != "3" {
+		t.Error("expected ParentKey(book) == 3, got", endpoint.ParentKey("book"))
+	}
+}
@@ -0,0 +1,45 @@
+package eprouter
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// Used when a HEAD request is served by a GET handler.
+// Headers (including Content-Length) and the status code are passed through, the body is thrown away.
+// WriteHeader is deferred until finish() so we can fill in a Content-Length if the handler didn't.
+type headResponseWriter struct {
+	http.ResponseWriter
+	statusCode int
+	bodyLength int
+}
+
+func newHeadResponseWriter(w http.ResponseWriter) *headResponseWriter {
+	return &headResponseWriter{ResponseWriter: w}
+}
+
+func (hw *headResponseWriter) WriteHeader(code int) {
+	if hw.statusCode == 0 {
+		hw.statusCode = code
+	}
+}
+
+func (hw *headResponseWriter) Write(b []byte) (int, error) {
+	if hw.statusCode == 0 {
+		hw.statusCode = http.StatusOK
+	}
+	hw.bodyLength += len(b)
+	return len(b), nil
+}
+
+func (hw *headResponseWriter) finish() {
+	if hw.statusCode == 0 {
+		// nothing was written
+		return
+	}
+	header := hw.ResponseWriter.Header()
+	if header.Get(HttpHeaderContentLength) == "" && hw.bodyLength > 0 {
+		header.Set(HttpHeaderContentLength, strconv.Itoa(hw.bodyLength))
+	}
+	hw.ResponseWriter.WriteHeader(hw.statusCode)
+}
@@ -0,0 +1,150 @@
+package eprouter
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// A method which looks like a handler, but won't be routed.
+type LintIssue struct {
+	ControllerName string
+	MethodName     string
+	ErrorNumber    int64
+	Reason         string
+}
+
+func (issue LintIssue) String() string {
+	return fmt.Sprintf("%s.%s: %d %s", issue.ControllerName, issue.MethodName, issue.ErrorNumber, issue.Reason)
+}
+
+// Reports every exported method of payloadController that looks like a handler but won't be routed by RegisterEntity.
+// eg: GetHandlerv1 (bad version), FetchHandlerV1 (unknown method), GetHandlerV1 w/ the wrong signature,
+// AuthGetHandlerV1 on a controller which doesn't implement AuthHandler, or Gethandlerv1 (misspelled keyword)
+func LintController(payloadController PayloadController) []LintIssue {
+	issues := []LintIssue{}
+	if payloadController == nil {
+		return issues
+	}
+
+	controllerType := reflect.TypeOf(payloadController)
+	receiver, receiverType := controllerReceiver(payloadController)
+	controllerName := controllerType.String()
+	authenticator, _ := payloadController.(AuthHandler)
+
+	for i := 0; i < controllerType.NumMethod(); i++ {
+		methodName := controllerType.Method(i).Name
+
+		if LooksLikeHandlerName(methodName) == false {
+			continue
+		}
+		if strings.Contains(methodName, MAGIC_HANDLER_KEYWORD) == false {
+			issues = append(issues, LintIssue{controllerName, methodName, 3146204471, misspelledHandlerKeywordReason})
+			continue
+		}
+
+		unknownhandler := newControllerMethod(receiver, receiverType, methodName)
+		_, skipped, derr := buildEntityRoute("lint", controllerName, methodName, unknownhandler, authenticator)
+		if derr == nil {
+			derr = skipped
+		}
+		if derr != nil {
+			issues = append(issues, LintIssue{controllerName, methodName, derr.Num, derr.EndUserMsg})
+		}
+	}
+	return issues
+}
+
+const misspelledHandlerKeywordReason = "looks like a handler, but the keyword must be spelled exactly '" + MAGIC_HANDLER_KEYWORD + "'"
+
+// true if the name contains the handler keyword as a word of its own (case-sensitive), or starts w/ a method prefix
+// whose keyword is misspelled.  The same methods RegisterEntity considers (and StrictRegistration rejects), plus those misspellings.
+// eg: GetHandlerV1, Gethandlerv1, FetchHandlerV2Popular, GetHandlerPopular, PostHandlersV1, GetHandler1
+// but not HandleRoutesEndpoint, or GetHandlerific
+func LooksLikeHandlerName(name string) bool {
+	return containsHandlerKeyword(name) || hasMisspelledHandlerPrefix(name)
+}
+
+var magicMethodPrefixes = []string{
+	MAGIC_GET_HANDLER_PREFIX,
+	MAGIC_LIST_HANDLER_PREFIX,
+	MAGIC_POST_HANDLER_PREFIX,
+	MAGIC_PUT_HANDLER_PREFIX,
+	MAGIC_PATCH_HANDLER_PREFIX,
+	MAGIC_DELETE_HANDLER_PREFIX,
+	MAGIC_HEAD_HANDLER_PREFIX,
+	MAGIC_OPTIONS_HANDLER_PREFIX,
+}
+
+// Handler (or Handlers), followed by the end of the name, an upper case letter, a digit or v<digit>.  eg: GetHandlerV1 but not RouteHandlerish
+func containsHandlerKeyword(name string) bool {
+	for start := 0; start < len(name); {
+		index := strings.Index(name[start:], MAGIC_HANDLER_KEYWORD)
+		if index < 0 {
+			return false
+		}
+		end := start + index + len(MAGIC_HANDLER_KEYWORD)
+		if end < len(name) && name[end] == 's' {
+			// plural, eg: PostHandlersV1
+			end++
+		}
+		if end == len(name) || isWordStart(name[end]) {
+			return true
+		}
+		if name[end] == 'v' && end+1 < len(name) && isWordStart(name[end+1]) {
+			// lower case version, eg: GetHandlerv1
+			return true
+		}
+		start += index + 1
+	}
+	return false
+}
+
+func isWordStart(c byte) bool {
+	return ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+}
+
+// eg: Gethandlerv1 or AuthDeletehandlerV1
+func hasMisspelledHandlerPrefix(name string) bool {
+	name = strings.TrimPrefix(name, MAGIC_AUTH_REQUIRED_PREFIX)
+	for _, prefix := range magicMethodPrefixes {
+		if len(name) >= len(prefix) && strings.EqualFold(name[:len(prefix)], prefix) && name[:len(prefix)] != prefix {
+			return true
+		}
+	}
+	return false
+}
+
+// Name only checks, for when there is no value to reflect on (eg: static analysis, see cmd/eprouterlint)
+func LintHandlerName(handlerName string) (isValid bool, reason string) {
+	if LooksLikeHandlerName(handlerName) == false {
+		return true, ""
+	}
+	if strings.Contains(handlerName, MAGIC_HANDLER_KEYWORD) == false {
+		return false, misspelledHandlerKeywordReason
+	}
+	_, _, _, _, _, skipped := parseHandlerName(handlerName)
+	if skipped != nil {
+		return false, skipped.EndUserMsg
+	}
+	return true, ""
+}
+
+// The subset of *testing.T used by LintControllersForTesting
+type LintTestingT interface {
+	Errorf(format string, args ...interface{})
+}
+
+// exported for other packages to be able to unit test.
+// Fails t for every lint issue found in any of the controllers.
+//
+//	func TestControllers(t *testing.T) {
+//		eprouter.LintControllersForTesting(t, &BookController{}, &AuthorController{})
+//	}
+func LintControllersForTesting(t LintTestingT, payloadControllers ...PayloadController) {
+	for _, payloadController := range payloadControllers {
+		for _, issue := range LintController(payloadController) {
+			t.Errorf("%s", issue)
+		}
+	}
+}
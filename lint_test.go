@@ -0,0 +1,101 @@
+package eprouter
+
+import (
+	"fmt"
+	"testing"
+)
+
+type NearMissController struct {
+}
+
+func (self *NearMissController) GetHandlerV1(ctx *Context) RouteHandlerResult {
+	return ctx.MakeRouteHandlerResultOk()
+}
+func (self *NearMissController) GetHandlerv1(ctx *Context) RouteHandlerResult {
+	return ctx.MakeRouteHandlerResultOk()
+}
+func (self *NearMissController) FetchHandlerV1(ctx *Context) RouteHandlerResult {
+	return ctx.MakeRouteHandlerResultOk()
+}
+func (self *NearMissController) PutHandlerV1(ctx *Context) string {
+	return ""
+}
+func (self *NearMissController) AuthPostHandlerV1(ctx *Context) RouteHandlerResult {
+	return ctx.MakeRouteHandlerResultOk()
+}
+func (self *NearMissController) DeletehandlerV1(ctx *Context) RouteHandlerResult {
+	return ctx.MakeRouteHandlerResultOk()
+}
+func (self *NearMissController) GetHandlerPopular(ctx *Context) RouteHandlerResult {
+	return ctx.MakeRouteHandlerResultOk()
+}
+func (self *NearMissController) PostHandlersV1(ctx *Context) RouteHandlerResult {
+	return ctx.MakeRouteHandlerResultOk()
+}
+func (self *NearMissController) GetHandler1(ctx *Context) RouteHandlerResult {
+	return ctx.MakeRouteHandlerResultOk()
+}
+func (self *NearMissController) SomethingElse() {
+}
+
+type lintRecorder struct {
+	messages []string
+}
+
+func (recorder *lintRecorder) Errorf(format string, args ...interface{}) {
+	recorder.messages = append(recorder.messages, fmt.Sprintf(format, args...))
+}
+
+func TestLintController(t *testing.T) {
+	issues := LintController(&NearMissController{})
+	expectedNums := map[string]int64{
+		"GetHandlerv1":      1259486570,
+		"FetchHandlerV1":    1860816435,
+		"PutHandlerV1":      3230075622,
+		"AuthPostHandlerV1": 1323798307,
+		"DeletehandlerV1":   3146204471,
+		"GetHandlerPopular": 0,
+		"PostHandlersV1":    0,
+		"GetHandler1":       0,
+	}
+
+	if len(issues) != len(expectedNums) {
+		t.Error("expected", len(expectedNums), "issues, got", len(issues), issues)
+	}
+	for _, issue := range issues {
+		expectedNum, isExpected := expectedNums[issue.MethodName]
+		if isExpected == false || (expectedNum != 0 && expectedNum != issue.ErrorNumber) {
+			t.Error("unexpected issue", issue)
+		}
+	}
+
+	// StrictRegistration rejects the same methods, except for the misspelled keyword, which it can't recognize
+	router := NewRouter()
+	router.StrictRegistration = true
+	regErr, _ := router.TryRegisterEntity("nearmiss", &NearMissController{}).(*RegistrationError)
+	if regErr == nil || len(regErr.Problems) != len(expectedNums)-1 {
+		t.Error("expected lint issues to match StrictRegistration problems, got", regErr)
+	}
+
+	recorder := new(lintRecorder)
+	LintControllersForTesting(recorder, &BookController{}, &AuthorController{}, &ShelfController{}, &NearMissController{})
+	// ShelfController.DeleteHandlerV1 has a non-pointer body
+	if len(recorder.messages) != len(expectedNums)+1 {
+		t.Error("expected", len(expectedNums)+1, "messages, got", recorder.messages)
+	}
+
+	if isValid, _ := LintHandlerName("GetHandlerV2Popular"); isValid == false {
+		t.Error("expected GetHandlerV2Popular to be valid")
+	}
+	if isValid, _ := LintHandlerName("GetBook"); isValid == false {
+		t.Error("expected GetBook to be ignored")
+	}
+	for _, name := range []string{"GetHandlerPopular", "PostHandlersV1", "GetHandler1", "MakeRouteHandlerResult"} {
+		if isValid, _ := LintHandlerName(name); isValid {
+			t.Error("expected", name, "to be invalid")
+		}
+	}
+	if isValid, _ := LintHandlerName("GetHandlerVX"); isValid {
+		t.Error("expected GetHandlerVX to be invalid")
+	}
+}
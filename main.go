@@ -18,12 +18,30 @@ func Start(routerPtr *Router, host string) error {
 	log.Println("All Routes:")
 	routerPtr.LogAllRoutes("↳ Route:")
 
+	return listenAndServe(routerPtr, host)
+}
+
+// Like Start, but serves several Routers mounted on a Mux
+func StartMux(mux *Mux, host string) error {
+	log.Printf("Starting eprouter Server (%v, eprouter v%v(%v))", runtime.Version(), Version(), BuildNumber())
+
+	if mux.AllRoutesCount() == 0 {
+		return fmt.Errorf("3621140791 Mux has no valid routes defined")
+	}
+
+	log.Println("All Routes:")
+	mux.LogAllRoutes("↳ Route:")
+
+	return listenAndServe(mux, host)
+}
+
+func listenAndServe(handler http.Handler, host string) error {
 	log.Println("Listening from ", host)
 	time.Sleep(100 * time.Millisecond) // give the log statements time to print...
 
-	err := http.ListenAndServe(host, routerPtr)
+	err := http.ListenAndServe(host, handler)
 	if err != nil {
-		return fmt.Errorf("3621140792 http.ListenAndServe returned error:", err)
+		return fmt.Errorf("3621140792 http.ListenAndServe returned error: %v", err)
 	}
 
 	return nil
@@ -0,0 +1,307 @@
+package eprouter
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// Streaming multipart/form-data
+//
+//	reader, err := ctx.MultipartReader(eprouter.MultipartOptions{
+//		MaxFileBytes:        10 << 20,
+//		AllowedContentTypes: []string{"application/pdf", "image/*"},
+//	})
+//	if err != nil {
+//		return ctx.MakeRouteHandlerResultFromError(err)
+//	}
+//	for {
+//		part, err := reader.NextPart()
+//		if err == io.EOF {
+//			break
+//		} else if err != nil {
+//			return ctx.MakeRouteHandlerResultFromError(err)
+//		}
+//		if part.IsFile() {
+//			_, err = storage.Save(part.FileName, part.ContentType, part) // a *RouteError if a limit is hit midway
+//			...
+//		}
+//	}
+//
+// Parts are never buffered to disk; each one must be consumed before the next (NextPart skips the rest of it).
+// The body is read via RequestBodyReader(), so Router.MaxBodyBytes still applies.
+
+const (
+	BadRequestInvalidMultipartErrorNumber = 4000000007
+	BadRequestInvalidMultipartPrefix      = BadRequestPrefix + ": Invalid Multipart Body"
+
+	// the number of bytes http.DetectContentType looks at
+	sniffLength = 512
+)
+
+type MultipartOptions struct {
+	MaxFileBytes  int64 // per file part.  0 is unlimited
+	MaxTotalBytes int64 // the whole body, including non-file fields, skipped parts and part headers.  0 is unlimited
+
+	// Media types allowed for file parts.  "image/*" matches any image type.  Empty allows everything, w/o sniffing.
+	// When set, the declared type must be allowed and match the detected (sniffed) one.
+	// A declared application/octet-stream is allowed if the detected type is, and becomes the part's ContentType.
+	AllowedContentTypes []string
+
+	// Skips sniffing; the declared Content-Type of file parts is checked against AllowedContentTypes as is.
+	// Useful for types http.DetectContentType can't tell apart (eg: docx vs zip).
+	TrustDeclaredContentType bool
+}
+
+type MultipartReader struct {
+	reader    *multipart.Reader
+	options   MultipartOptions
+	totalRead int64
+	current   *MultipartPart
+}
+
+// One form field or uploaded file.  Read it like any io.Reader.
+type MultipartPart struct {
+	FormName            string
+	FileName            string // empty for regular form fields
+	DeclaredContentType string // from the part's headers, w/o parameters
+	ContentType         string // DeclaredContentType w/o aliases (eg: image/jpg), or the detected type for application/octet-stream files
+	Header              textproto.MIMEHeader
+
+	part      *multipart.Part
+	parent    *MultipartReader
+	read      int64
+	buffered  *bufio.Reader
+	streamErr error // sticky, once a limit is hit
+}
+
+// Starts streaming a multipart/form-data body.  Any other Content-Type is a 415.
+// Errors are *RouteErrors, which can be passed straight to ctx.MakeRouteHandlerResultFromError.
+func (ctx *Context) MultipartReader(options MultipartOptions) (*MultipartReader, error) {
+	contentType := ctx.Req.Header.Get(HttpHeaderContentType)
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType != "multipart/form-data" {
+		return nil, NewRouteError(http.StatusUnsupportedMediaType, ErrorInfo{ErrorNumber: UnsupportedMediaTypeErrorNumber, ErrorMessage: UnsupportedMediaTypePrefix, DebugMessage: "expected multipart/form-data, got " + contentType})
+	}
+	if params["boundary"] == "" {
+		return nil, NewRouteError(http.StatusBadRequest, ErrorInfo{ErrorNumber: BadRequestInvalidMultipartErrorNumber, ErrorMessage: BadRequestInvalidMultipartPrefix, DebugMessage: "missing boundary"})
+	}
+
+	mr := new(MultipartReader)
+	mr.options = options
+	// counted at the source, so parts which are skipped (and drained by NextPart) count as well
+	mr.reader = multipart.NewReader(totalCountingReader{ctx.RequestBodyReader(), mr}, params["boundary"])
+	return mr, nil
+}
+
+// returns io.EOF after the last part.
+// If AllowedContentTypes is set, file parts are checked against it, and their declared type against the sniffed one, before being returned.
+func (mr *MultipartReader) NextPart() (*MultipartPart, error) {
+	if mr.current != nil && mr.current.streamErr != nil {
+		// can't continue past a limit
+		return nil, mr.current.streamErr
+	}
+
+	rawPart, err := mr.reader.NextPart()
+	if err != nil {
+		return nil, multipartReadError(err)
+	}
+
+	part := &MultipartPart{
+		FormName: rawPart.FormName(),
+		FileName: rawPart.FileName(),
+		Header:   rawPart.Header,
+		part:     rawPart,
+		parent:   mr,
+	}
+	part.buffered = bufio.NewReaderSize(limitedPartReader{part}, sniffLength)
+	mr.current = part
+
+	declared := rawPart.Header.Get(HttpHeaderContentType)
+	if declared == "" {
+		// RFC 7578 defaults
+		declared = "text/plain"
+		if part.IsFile() {
+			declared = "application/octet-stream"
+		}
+	}
+	if mediaType, _, err := mime.ParseMediaType(declared); err == nil {
+		declared = mediaType
+	}
+	part.DeclaredContentType = declared
+	declared = canonicalMediaType(declared)
+	part.ContentType = declared
+
+	allowed := mr.options.AllowedContentTypes
+	if part.IsFile() == false || len(allowed) == 0 {
+		// nothing to protect, the declared type is taken at face value
+		return part, nil
+	}
+
+	// application/octet-stream just means "some file", the sniffed type decides below
+	isGeneric := declared == "application/octet-stream"
+	if isGeneric == false || mr.options.TrustDeclaredContentType {
+		if contentTypeAllowed(declared, allowed) == false {
+			return nil, part.unsupportedMediaTypeError(declared + " is not allowed")
+		}
+		if mr.options.TrustDeclaredContentType {
+			return part, nil
+		}
+	}
+
+	sniffed, err := part.buffered.Peek(sniffLength)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, multipartReadError(err)
+	}
+	detected, _, _ := mime.ParseMediaType(http.DetectContentType(sniffed))
+	switch {
+	case isGeneric:
+		if detected != declared && contentTypeAllowed(detected, allowed) {
+			part.ContentType = detected
+		} else if contentTypeAllowed(declared, allowed) == false {
+			return nil, part.unsupportedMediaTypeError("declared as " + declared + ", detected " + detected + ", which is not allowed")
+		}
+	case detected == declared:
+	case (detected == "text/plain" || detected == "text/xml") && isTextMediaType(declared):
+		// eg: text/csv or application/json, DetectContentType doesn't know about those
+	default:
+		return nil, part.unsupportedMediaTypeError("declared as " + declared + ", but detected " + detected)
+	}
+	return part, nil
+}
+
+// common aliases, as sent by browsers and older clients, to what http.DetectContentType reports
+var mediaTypeAliases = map[string]string{
+	"image/jpg":         "image/jpeg",
+	"image/pjpeg":       "image/jpeg",
+	"image/x-png":       "image/png",
+	"application/x-pdf": "application/pdf",
+}
+
+func canonicalMediaType(mediaType string) string {
+	if canonical, exists := mediaTypeAliases[mediaType]; exists {
+		return canonical
+	}
+	return mediaType
+}
+
+// types whose content sniffs as text/plain (or text/xml).  eg: text/csv, application/json, application/vnd.api+json
+func isTextMediaType(mediaType string) bool {
+	if strings.HasPrefix(mediaType, "text/") || strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml") {
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/xml", "application/javascript", "application/x-ndjson", "application/yaml", "application/x-yaml":
+		return true
+	}
+	return false
+}
+
+func (part *MultipartPart) IsFile() bool {
+	return part.FileName != ""
+}
+
+// Reads past MaxFileBytes or MaxTotalBytes fail w/ a *RouteError (413).  So does NextPart, for MaxTotalBytes
+func (part *MultipartPart) Read(p []byte) (int, error) {
+	return part.buffered.Read(p)
+}
+
+// "image/*" style wildcards are allowed
+func contentTypeAllowed(mediaType string, allowed []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, candidate := range allowed {
+		if candidate == mediaType {
+			return true
+		}
+		if strings.HasSuffix(candidate, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(candidate, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+func (part *MultipartPart) unsupportedMediaTypeError(reason string) *RouteError {
+	return NewRouteError(http.StatusUnsupportedMediaType, ErrorInfo{
+		ErrorNumber:  UnsupportedMediaTypeErrorNumber,
+		ErrorMessage: UnsupportedMediaTypePrefix,
+		FieldErrors:  []FieldError{{Field: part.FormName, Message: reason}},
+	})
+}
+
+func (part *MultipartPart) tooLargeError(limit int64, what string) *RouteError {
+	return NewRouteError(http.StatusRequestEntityTooLarge, ErrorInfo{
+		ErrorNumber:  RequestEntityTooLargeErrorNumber,
+		ErrorMessage: RequestEntityTooLargePrefix,
+		FieldErrors:  []FieldError{{Field: part.FormName, Message: what + " is limited to " + strconv.FormatInt(limit, 10) + " bytes"}},
+	})
+}
+
+// *RouteErrors (eg: Router.MaxBodyBytes or MaxTotalBytes) pass through, even when wrapped by mime/multipart.
+// anything else is a malformed body
+func multipartReadError(err error) error {
+	if err == io.EOF {
+		return err
+	}
+	var rerr *RouteError
+	if errors.As(err, &rerr) {
+		return rerr
+	}
+	return NewRouteError(http.StatusBadRequest, ErrorInfo{ErrorNumber: BadRequestInvalidMultipartErrorNumber, ErrorMessage: BadRequestInvalidMultipartPrefix, DebugMessage: err.Error()})
+}
+
+// sits underneath the bufio.Reader, so sniffing counts towards MaxFileBytes too
+type limitedPartReader struct {
+	part *MultipartPart
+}
+
+func (lr limitedPartReader) Read(p []byte) (int, error) {
+	part := lr.part
+	if part.streamErr != nil {
+		return 0, part.streamErr
+	}
+	n, err := part.part.Read(p)
+	part.read += int64(n)
+
+	maxFileBytes := part.parent.options.MaxFileBytes
+	if part.IsFile() && maxFileBytes > 0 && part.read > maxFileBytes {
+		part.streamErr = part.tooLargeError(maxFileBytes, "each file")
+		return 0, part.streamErr
+	}
+	if err != nil {
+		err = multipartReadError(err)
+		if err != io.EOF {
+			part.streamErr = err
+		}
+		return n, err
+	}
+	return n, nil
+}
+
+// counts everything mime/multipart reads from the body towards MaxTotalBytes
+type totalCountingReader struct {
+	body   io.Reader
+	parent *MultipartReader
+}
+
+func (tr totalCountingReader) Read(p []byte) (int, error) {
+	mr := tr.parent
+	n, err := tr.body.Read(p)
+	mr.totalRead += int64(n)
+	if limit := mr.options.MaxTotalBytes; limit > 0 && mr.totalRead > limit {
+		// mime/multipart reads ahead, so there is no telling which part was being read
+		return 0, NewRouteError(http.StatusRequestEntityTooLarge, ErrorInfo{
+			ErrorNumber:  RequestEntityTooLargeErrorNumber,
+			ErrorMessage: RequestEntityTooLargePrefix,
+			DebugMessage: "the upload is limited to " + strconv.FormatInt(limit, 10) + " bytes",
+		})
+	}
+	return n, err
+}
@@ -0,0 +1,187 @@
+package eprouter
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+var pdfBytes = []byte("%PDF-1.4\n% fake claim document\n")
+var pngBytes = []byte("\x89PNG\x0D\x0A\x1A\x0A fake scan")
+var jpegBytes = []byte("\xFF\xD8\xFF\xE0 fake photo")
+
+type testPart struct {
+	formName, fileName, contentType string
+	content                         []byte
+}
+
+func multipartRequestForTesting(parts ...testPart) *http.Request {
+	body := new(bytes.Buffer)
+	writer := multipart.NewWriter(body)
+	for _, part := range parts {
+		header := textproto.MIMEHeader{}
+		disposition := `form-data; name="` + part.formName + `"`
+		if part.fileName != "" {
+			disposition += `; filename="` + part.fileName + `"`
+		}
+		header.Set("Content-Disposition", disposition)
+		if part.contentType != "" {
+			header.Set(HttpHeaderContentType, part.contentType)
+		}
+		partWriter, _ := writer.CreatePart(header)
+		partWriter.Write(part.content)
+	}
+	writer.Close()
+
+	req := httptest.NewRequest("POST", "/api/v1/claim/7/documents", body)
+	req.Header.Set(HttpHeaderContentType, writer.FormDataContentType())
+	return req
+}
+
+// reads every part, returns the names and sizes read, and the first error
+func readAllPartsForTesting(req *http.Request, options MultipartOptions) (map[string]int, error) {
+	ctx := new(Context)
+	ctx.Req = req
+	reader, err := ctx.MultipartReader(options)
+	if err != nil {
+		return nil, err
+	}
+	sizes := map[string]int{}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return sizes, nil
+		} else if err != nil {
+			return sizes, err
+		}
+		content, err := ioutil.ReadAll(part)
+		if err != nil {
+			return sizes, err
+		}
+		sizes[part.FormName+":"+part.ContentType] = len(content)
+	}
+}
+
+func TestMultipartReader(t *testing.T) {
+	options := MultipartOptions{AllowedContentTypes: []string{"application/pdf", "image/*"}, MaxFileBytes: 64}
+	sizes, err := readAllPartsForTesting(multipartRequestForTesting(
+		testPart{"memberId", "", "", []byte("42")},
+		testPart{"claim", "claim.pdf", "application/pdf", pdfBytes},
+		testPart{"scan", "scan.png", "image/png", pngBytes},
+	), options)
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	expected := map[string]int{"memberId:text/plain": 2, "claim:application/pdf": len(pdfBytes), "scan:image/png": len(pngBytes)}
+	for key, size := range expected {
+		if sizes[key] != size {
+			t.Error("expected", key, size, "bytes, got", sizes)
+		}
+	}
+
+	// the detected type replaces application/octet-stream, and aliases are resolved
+	sizes, err = readAllPartsForTesting(multipartRequestForTesting(
+		testPart{"scan", "scan.bin", "application/octet-stream", pngBytes},
+		testPart{"photo", "photo.jpg", "image/jpg", jpegBytes},
+	), options)
+	if err != nil || sizes["scan:image/png"] != len(pngBytes) || sizes["photo:image/jpeg"] != len(jpegBytes) {
+		t.Error("expected image/png and image/jpeg, got", sizes, err)
+	}
+
+	type testCase struct {
+		options            MultipartOptions
+		parts              []testPart
+		expectedStatusCode int
+		expectedField      string
+	}
+	testCases := []testCase{
+		// not allowed
+		{options, []testPart{{"claim", "claim.txt", "text/plain", []byte("hello")}}, http.StatusUnsupportedMediaType, "claim"},
+		// declared as a pdf, but it's a png
+		{options, []testPart{{"claim", "claim.pdf", "application/pdf", pngBytes}}, http.StatusUnsupportedMediaType, "claim"},
+		// declared as a pdf, but unrecognizable
+		{options, []testPart{{"claim", "claim.pdf", "application/pdf", []byte{0, 1, 2, 3}}}, http.StatusUnsupportedMediaType, "claim"},
+		// unless sniffing is off
+		{MultipartOptions{TrustDeclaredContentType: true, AllowedContentTypes: []string{"application/pdf"}}, []testPart{{"claim", "claim.pdf", "application/pdf", []byte{0, 1, 2, 3}}}, 0, ""},
+		// csv sniffs as text/plain
+		{MultipartOptions{}, []testPart{{"roster", "roster.csv", "text/csv", []byte("a,b\n1,2\n")}}, 0, ""},
+		// w/o an allowlist, nothing is sniffed
+		{MultipartOptions{}, []testPart{{"data", "data.json", "application/json", []byte(`{"a":1}`)}}, 0, ""},
+		{MultipartOptions{}, []testPart{{"blob", "blob.bin", "", pngBytes}}, 0, ""},
+		{MultipartOptions{}, []testPart{{"blob", "blob.bin", "application/octet-stream", pngBytes}}, 0, ""},
+		{MultipartOptions{}, []testPart{{"photo", "photo.jpg", "image/jpg", jpegBytes}}, 0, ""},
+		{MultipartOptions{}, []testPart{{"claim", "claim.pdf", "application/pdf", pngBytes}}, 0, ""},
+		// w/ one, text based types sniff as text, aliases are resolved, and octet-stream goes by the content
+		{MultipartOptions{AllowedContentTypes: []string{"application/json"}}, []testPart{{"data", "data.json", "application/json", []byte(`{"a":1}`)}}, 0, ""},
+		{MultipartOptions{AllowedContentTypes: []string{"application/xml"}}, []testPart{{"data", "data.xml", "application/xml", []byte(`<?xml version="1.0"?><a/>`)}}, 0, ""},
+		{options, []testPart{{"photo", "photo.jpg", "image/jpg", jpegBytes}}, 0, ""},
+		{options, []testPart{{"scan", "scan.bin", "application/octet-stream", pngBytes}}, 0, ""},
+		{options, []testPart{{"scan", "scan.bin", "", pngBytes}}, 0, ""},
+		{options, []testPart{{"scan", "scan.bin", "application/octet-stream", []byte{0, 1, 2, 3}}}, http.StatusUnsupportedMediaType, "scan"},
+		{options, []testPart{{"photo", "photo.jpg", "image/jpg", pngBytes}}, http.StatusUnsupportedMediaType, "photo"},
+		// per file
+		{options, []testPart{{"claim", "claim.pdf", "application/pdf", append(append([]byte{}, pdfBytes...), bytes.Repeat([]byte("x"), 64)...)}}, http.StatusRequestEntityTooLarge, "claim"},
+		// total, counting part headers
+		{MultipartOptions{MaxTotalBytes: 300}, []testPart{{"claim", "claim.pdf", "application/pdf", pdfBytes}, {"scan", "scan.png", "image/png", pngBytes}}, http.StatusRequestEntityTooLarge, ""},
+	}
+	for i, tc := range testCases {
+		_, err := readAllPartsForTesting(multipartRequestForTesting(tc.parts...), tc.options)
+		if tc.expectedStatusCode == 0 {
+			if err != nil {
+				t.Error(i, "unexpected error", err)
+			}
+			continue
+		}
+		rerr, isRouteError := err.(*RouteError)
+		if isRouteError == false {
+			t.Error(i, "expected *RouteError, got", err)
+			continue
+		}
+		if tc.expectedField == "" {
+			if rerr.statusCode != tc.expectedStatusCode || len(rerr.errorInfo.FieldErrors) != 0 {
+				t.Error(i, "expected", tc.expectedStatusCode, "got", rerr.statusCode, rerr.errorInfo)
+			}
+			continue
+		}
+		if rerr.statusCode != tc.expectedStatusCode || len(rerr.errorInfo.FieldErrors) != 1 || rerr.errorInfo.FieldErrors[0].Field != tc.expectedField {
+			t.Error(i, "expected", tc.expectedStatusCode, "for", tc.expectedField, "got", rerr.statusCode, rerr.errorInfo)
+		}
+	}
+
+	// parts which are skipped count towards the total as well
+	ctx := new(Context)
+	ctx.Req = multipartRequestForTesting(testPart{"a", "", "", bytes.Repeat([]byte("a"), 5000)}, testPart{"b", "", "", bytes.Repeat([]byte("b"), 5000)})
+	reader, _ := ctx.MultipartReader(MultipartOptions{MaxTotalBytes: 100})
+	for {
+		_, err := reader.NextPart()
+		if err == io.EOF {
+			t.Error("expected skipped parts to exceed MaxTotalBytes")
+			break
+		} else if err != nil {
+			if rerr, isRouteError := err.(*RouteError); isRouteError == false || rerr.statusCode != http.StatusRequestEntityTooLarge {
+				t.Error("expected 413, got", err)
+			}
+			break
+		}
+	}
+
+	// not multipart
+	req := httptest.NewRequest("POST", "/api/v1/claim/7/documents", strings.NewReader("{}"))
+	req.Header.Set(HttpHeaderContentType, HttpHeaderContentTypeJSON)
+	if _, err := readAllPartsForTesting(req, options); err == nil || err.(*RouteError).statusCode != http.StatusUnsupportedMediaType {
+		t.Error("expected 415 for a JSON body, got", err)
+	}
+
+	// malformed
+	req = httptest.NewRequest("POST", "/api/v1/claim/7/documents", strings.NewReader("--nope\r\ngarbage"))
+	req.Header.Set(HttpHeaderContentType, "multipart/form-data; boundary=nope")
+	if _, err := readAllPartsForTesting(req, options); err == nil || err.(*RouteError).statusCode != http.StatusBadRequest {
+		t.Error("expected 400 for a malformed body, got", err)
+	}
+}
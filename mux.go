@@ -0,0 +1,136 @@
+package eprouter
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// A Mux serves several Routers from a single http.Handler, each mounted under its own base path.
+// eg: a public API under /api/, an internal API under /internal/ and partner endpoints under /partner/
+// Each Router keeps its own Pre/Middleware/Post processors and Authenticators.
+// Requests are dispatched to the Router with the longest matching base path.
+type Mux struct {
+	// Only used for requests which don't match any mounted Router.
+	// Matched requests use the processors of the Router they are dispatched to.
+	PreProcessors  []PreProcessor
+	PostProcessors []PostProcessor
+
+	routers []*Router // sorted by base path, longest first
+}
+
+func NewMux() *Mux {
+	mux := new(Mux)
+	mux.PreProcessors = []PreProcessor{}
+	mux.PostProcessors = []PostProcessor{
+		new(CommonLogger),
+	}
+	return mux
+}
+
+// Mounts router under basePath.  This overwrites router.BasePath.
+func (mux *Mux) Mount(basePath string, router *Router) {
+	if router == nil {
+		log.Fatalln("1583046721 cannot mount nil router at", basePath)
+	}
+	for _, existing := range mux.routers {
+		if normalizeBasePath(existing.BasePath) == normalizeBasePath(basePath) {
+			log.Fatalln("1583046722 a router is already mounted at", basePath)
+		}
+	}
+
+	router.BasePath = basePath
+	mux.routers = append(mux.routers, router)
+	sort.SliceStable(mux.routers, func(i, j int) bool {
+		return len(normalizeBasePath(mux.routers[i].BasePath)) > len(normalizeBasePath(mux.routers[j].BasePath))
+	})
+}
+
+// Convenience method
+func (mux *Mux) AllRoutesCount() int {
+	count := 0
+	for _, router := range mux.routers {
+		count += router.AllRoutesCount()
+	}
+	return count
+}
+
+// Basically just used for logging and debugging.
+// the first addon is a prefix, all remaining addons are treated as suffixes and appended to the end
+func (mux *Mux) AllRoutesDescription(addons ...string) []string {
+	lines := []string{}
+	for _, router := range mux.routers {
+		lines = append(lines, router.AllRoutesDescription(addons...)...)
+	}
+	return lines
+}
+
+// Every route of every mounted router, see Router.Routes()
+func (mux *Mux) Routes() []RouteInfo {
+	infos := []RouteInfo{}
+	for _, router := range mux.routers {
+		infos = append(infos, router.Routes()...)
+	}
+	return infos
+}
+
+func (mux *Mux) LogAllRoutes(addons ...string) {
+	for _, line := range mux.AllRoutesDescription(addons...) {
+		log.Println(line)
+	}
+}
+
+func (mux *Mux) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	router := mux.routerForPath(req.URL.EscapedPath())
+	if router != nil {
+		router.ServeHTTP(w, req)
+		return
+	}
+
+	// No router for this path.  404, but keep the same output format and logging as a Router would.
+	ctx := new(Context)
+	ctx.w = w
+	ctx.Req = req
+
+	defer ctx.runCleanups()
+	defer func() {
+		for _, postproc := range mux.PostProcessors {
+			terminateEarly, derr := postproc.Process(ctx)
+			if derr != nil {
+				log.Println(derr)
+			}
+			if terminateEarly {
+				return
+			}
+		}
+	}()
+
+	for _, preproc := range mux.PreProcessors {
+		terminateEarly, derr := preproc.Process(ctx)
+		if derr != nil {
+			log.Println(derr)
+		}
+		if terminateEarly {
+			return
+		}
+	}
+
+	ctx.SendSimpleErrorPayload(http.StatusNotFound, 3475081071, NotFoundPrefix)
+}
+
+func (mux *Mux) routerForPath(urlPath string) *Router {
+	urlPath = strings.Trim(urlPath, "/")
+	for _, router := range mux.routers {
+		basePath := normalizeBasePath(router.BasePath)
+		// match whole path segments only, /api/ should not match /apiv2/
+		if basePath == "" || urlPath == basePath || strings.HasPrefix(urlPath, basePath+"/") {
+			return router
+		}
+	}
+	return nil
+}
+
+func normalizeBasePath(basePath string) string {
+	return strings.Trim(basePath, "/")
+}
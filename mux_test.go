@@ -0,0 +1,82 @@
+package eprouter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/amattn/deeperror"
+)
+
+type countingPostProcessor struct {
+	count int
+}
+
+func (cpp *countingPostProcessor) Process(ctx *Context) (terminateEarly bool, derr *deeperror.DeepError) {
+	cpp.count++
+	return false, nil
+}
+
+func TestMux(t *testing.T) {
+	public := NewRouter()
+	public.RegisterEntity("book", &BookController{})
+	publicCounter := new(countingPostProcessor)
+	public.PostProcessors = append(public.PostProcessors, publicCounter)
+
+	internal := NewRouter()
+	internal.RegisterEntity("author", &AuthorController{})
+	internalCounter := new(countingPostProcessor)
+	internal.PostProcessors = append(internal.PostProcessors, internalCounter)
+
+	partner := NewRouter()
+	partner.RegisterEntity("book", &AuthorController{})
+
+	mux := NewMux()
+	mux.Mount("/api/", public)
+	mux.Mount("/internal/", internal)
+	mux.Mount("/api/partner/", partner)
+	muxCounter := new(countingPostProcessor)
+	mux.PostProcessors = append(mux.PostProcessors, muxCounter)
+
+	if mux.AllRoutesCount() != public.AllRoutesCount()+internal.AllRoutesCount()+partner.AllRoutesCount() {
+		t.Error("unexpected AllRoutesCount", mux.AllRoutesCount())
+	}
+
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	getURLAndStatusCodes := map[string]int{
+		"/api/v1/book/1":           http.StatusOK,
+		"/api/v1/author/1":         http.StatusNotFound,
+		"/internal/v1/author/1":    http.StatusOK,
+		"/internal/v1/book/1":      http.StatusNotFound,
+		"/api/partner/v1/book/5":   http.StatusOK, // AuthorController always returns ok
+		"/apiv2/v1/book/1":         http.StatusNotFound,
+		"/bogus/v1/book/1":         http.StatusNotFound,
+		"/partner/api/v1/book/1/x": http.StatusNotFound,
+	}
+
+	for urlsuffix, expectedStatusCode := range getURLAndStatusCodes {
+		response, err := http.Get(ts.URL + urlsuffix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		if response.StatusCode != expectedStatusCode {
+			t.Error("GET", urlsuffix, "expected ", expectedStatusCode, ", got", response.StatusCode)
+		}
+		if ct := response.Header.Get(HttpHeaderContentType); ct != HttpHeaderContentTypeJSON {
+			t.Error("GET", urlsuffix, "expected Content-Type", HttpHeaderContentTypeJSON, "got", ct)
+		}
+	}
+
+	if publicCounter.count != 2 {
+		t.Error("expected 2 requests through the public router, got", publicCounter.count)
+	}
+	if internalCounter.count != 2 {
+		t.Error("expected 2 requests through the internal router, got", internalCounter.count)
+	}
+	if muxCounter.count != 3 {
+		t.Error("expected 3 unmatched requests through the mux, got", muxCounter.count)
+	}
+}
@@ -0,0 +1,108 @@
+package eprouter
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Media type versioning, eg: Accept: application/vnd.collectivehealth.book.v2+json
+//
+// Only used when Router.VendorMediaTypeName is set, and only for paths without a /v<n>/ segment.
+// Path versions always take precedence.
+
+// returns the version (leading zeros trimmed) from the best matching vendor media type in the Accept header(s),
+// along w/ the media type itself (lowercased, without parameters).
+// "", "" if none match.  the highest q wins, ties go to the first listed.
+// Media types naming an entity other than the path's (eg: book for /author/3) are ignored.
+func negotiateVendorVersion(acceptHeaders []string, vendorName string, endpoint *Endpoint) (versionStr string, mediaType string) {
+	re := vendorMediaTypeRegexp(vendorName)
+	bestQ := 0.0
+	for _, acceptHeader := range acceptHeaders {
+		for _, mediaRange := range strings.Split(acceptHeader, ",") {
+			params := strings.Split(mediaRange, ";")
+			candidate := strings.ToLower(strings.TrimSpace(params[0]))
+			matches := re.FindStringSubmatch(candidate)
+			if matches == nil {
+				continue
+			}
+			if matches[1] != "" && isEndpointEntity(matches[1], endpoint) == false {
+				continue
+			}
+			candidateVersionStr := strings.TrimLeft(matches[2], "0")
+			if v64, err := strconv.ParseUint(candidateVersionStr, 10, VERSION_BIT_DEPTH); err != nil || v64 == 0 {
+				continue
+			}
+			q := acceptQuality(params[1:])
+			if q > bestQ {
+				bestQ = q
+				versionStr = candidateVersionStr
+				mediaType = candidate
+			}
+		}
+	}
+	return versionStr, mediaType
+}
+
+// eg: book or author.book for /author/7/book/3
+func isEndpointEntity(mediaTypeEntity string, endpoint *Endpoint) bool {
+	return mediaTypeEntity == strings.ToLower(endpoint.EntityName) ||
+		mediaTypeEntity == strings.ToLower(strings.Replace(endpoint.EntityPath(), "/", ".", -1))
+}
+
+var vendorMediaTypeRegexpCache sync.Map // key is the lowercased vendor name, value is *regexp.Regexp
+
+// application/vnd.<vendor>[.<entity>].v<n>+json
+// compiled once per vendor name, not per request
+func vendorMediaTypeRegexp(vendorName string) *regexp.Regexp {
+	vendorName = strings.ToLower(vendorName)
+	if cached, exists := vendorMediaTypeRegexpCache.Load(vendorName); exists {
+		return cached.(*regexp.Regexp)
+	}
+	re := regexp.MustCompile(`^application/vnd\.` + regexp.QuoteMeta(vendorName) + `(?:\.([a-z0-9_.-]+?))?\.v([0-9]+)\+json$`)
+	// if two requests race here, both regexps are identical, so it doesn't matter who wins
+	vendorMediaTypeRegexpCache.Store(vendorName, re)
+	return re
+}
+
+// q defaults to 1, malformed values are treated as 0 (not acceptable)
+func acceptQuality(params []string) float64 {
+	for _, param := range params {
+		keyValue := strings.SplitN(strings.TrimSpace(param), "=", 2)
+		if len(keyValue) == 2 && strings.EqualFold(keyValue[0], "q") {
+			q, err := strconv.ParseFloat(strings.TrimSpace(keyValue[1]), 64)
+			if err != nil {
+				return 0
+			}
+			return q
+		}
+	}
+	return 1
+}
+
+// Fills in ctx.Endpoint.VersionStr from the Accept header, if the path didn't have a version.
+func (router *Router) negotiateVersion(ctx *Context) {
+	if router.VendorMediaTypeName == "" || ctx.Endpoint.entityIndex > 0 {
+		return
+	}
+	// the response depends on Accept, let caches know
+	ctx.AddResponseHeader(HttpHeaderVary, HttpHeaderAccept)
+
+	versionStr, mediaType := negotiateVendorVersion(ctx.Req.Header[HttpHeaderAccept], router.VendorMediaTypeName, &ctx.Endpoint)
+	if versionStr == "" {
+		return
+	}
+	ctx.Endpoint.setVersionStr(versionStr)
+	ctx.negotiatedMediaType = mediaType
+}
+
+// The Content-Type of JSON responses: the negotiated vendor media type if there is one, plain application/json otherwise.
+// Errors are always plain application/json, they aren't a representation of the entity.
+func (ctx *Context) jsonContentType(statusCode int) string {
+	if ctx.negotiatedMediaType != "" && statusCode < http.StatusBadRequest {
+		return ctx.negotiatedMediaType
+	}
+	return HttpHeaderContentTypeJSON
+}
@@ -0,0 +1,44 @@
+package eprouter
+
+import (
+	"testing"
+)
+
+func TestNegotiateVendorVersion(t *testing.T) {
+	if vendorMediaTypeRegexp("CollectiveHealth") != vendorMediaTypeRegexp("collectivehealth") {
+		t.Error("expected the vendor media type regexp to be compiled once per vendor name")
+	}
+
+	accept := []string{"application/json;q=0.5, application/vnd.collectivehealth.book.v2+json"}
+	book := &Endpoint{EntityName: "book"}
+	versionStr, mediaType := negotiateVendorVersion(accept, "collectivehealth", book)
+	if versionStr != "2" || mediaType != "application/vnd.collectivehealth.book.v2+json" {
+		t.Error("expected version 2, got", versionStr, mediaType)
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		negotiateVendorVersion(accept, "collectivehealth", book)
+	})
+	if allocs > 20 {
+		t.Error("expected no regexp compilation per call, got", allocs, "allocations")
+	}
+
+	type testCase struct {
+		accept          string
+		endpoint        *Endpoint
+		expectedVersion string
+	}
+	testCases := []testCase{
+		{"application/vnd.collectivehealth.book.v2+json", &Endpoint{EntityName: "author"}, ""},
+		{"application/vnd.collectivehealth.v2+json", &Endpoint{EntityName: "author"}, "2"},
+		{"application/vnd.collectivehealth.Book.v2+json", book, "2"},
+		{"application/vnd.collectivehealth.book.v2+json", &Endpoint{EntityName: "book", Parents: []ParentEntity{{"author", "7"}}}, "2"},
+		{"application/vnd.collectivehealth.author.book.v2+json", &Endpoint{EntityName: "book", Parents: []ParentEntity{{"author", "7"}}}, "2"},
+		{"application/vnd.collectivehealth.author.v2+json", &Endpoint{EntityName: "book", Parents: []ParentEntity{{"author", "7"}}}, ""},
+	}
+	for _, tc := range testCases {
+		if versionStr, _ := negotiateVendorVersion([]string{tc.accept}, "collectivehealth", tc.endpoint); versionStr != tc.expectedVersion {
+			t.Error(tc.accept, tc.endpoint.EntityPath(), "expected version", tc.expectedVersion, "got", versionStr)
+		}
+	}
+}
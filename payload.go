@@ -6,6 +6,7 @@ import (
 	"log"
 	"net/http"
 	"reflect"
+	"strconv"
 
 	"github.com/amattn/deeperror"
 )
@@ -25,6 +26,8 @@ type ErrorInfo struct {
 	ErrorMessage string `json:"errorMessage,omitempty"` // end-user appropriate error message
 	DebugNumber  int64  `json:"debugNumber,omitempty"`  // optional debug code
 	DebugMessage string `json:"debugMessage,omitempty"` // optional debug message
+
+	FieldErrors []FieldError `json:"fieldErrors,omitempty"` // optional, every offending parameter of a 400 (eg: from BindQuery)
 }
 
 // This will typically be serialized into a JSON formatted string
@@ -139,7 +142,7 @@ func writePayloadWrapper(ctx *Context, code int, payloadWrapper *PayloadWrapper)
 
 	ctx.written = true
 	ctx.StatusCode = code
-	ctx.SetResponseHeader(HttpHeaderContentType, HttpHeaderContentTypeJSON)
+	ctx.SetResponseHeader(HttpHeaderContentType, ctx.jsonContentType(code))
 
 	// This is the old way.  it doesn't give us status info.
 	// enc := json.NewEncoder(ctx.w)
@@ -164,6 +167,7 @@ func writePayloadWrapper(ctx *Context, code int, payloadWrapper *PayloadWrapper)
 	} else {
 		// At this point, everything is a-ok...  just write out.
 		if rw, isResponseWriter := ctx.w.(http.ResponseWriter); isResponseWriter {
+			ctx.SetResponseHeader(HttpHeaderContentLength, strconv.Itoa(len(jsonBytes)))
 			rw.WriteHeader(code)
 			if len(jsonBytes) == 0 {
 				log.Println("jsonBytes", jsonBytes, ctx.Req.URL)
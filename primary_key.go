@@ -0,0 +1,121 @@
+package eprouter
+
+import (
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Optional.  If a PayloadController implements this, RegisterEntity validates primary keys before any of its handlers run.
+// Malformed keys are answered w/ a 400 and BadRequestMalformedPrimaryKeyErrorNumber, and the typed key is available
+// via ctx.Endpoint.TypedPrimaryKey() (or PrimaryKeyInt64, PrimaryKeyUint64, PrimaryKeyUUID).
+//
+//	func (bc *BookController) ParsePrimaryKey(rawKey string) (interface{}, error) {
+//		return eprouter.ParseInt64PrimaryKey(rawKey)
+//	}
+//
+// Only the endpoint's own primary key is parsed, not the keys of any parents.
+type PrimaryKeyParser interface {
+	ParsePrimaryKey(rawKey string) (interface{}, error)
+}
+
+// Same as PrimaryKeyParser.ParsePrimaryKey, for explicit routes via RouteOptions.KeyParser
+type PrimaryKeyParseFunc func(rawKey string) (interface{}, error)
+
+// A parsed UUID, see ParseUUIDPrimaryKey
+type UUID [16]byte
+
+// canonical, lowercased form. eg: 6ba7b810-9dad-11d1-80b4-00c04fd430c8
+func (uuid UUID) String() string {
+	buf := make([]byte, 36)
+	hex.Encode(buf[0:8], uuid[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], uuid[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], uuid[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], uuid[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], uuid[10:])
+	return string(buf)
+}
+
+// Built in parsers, usable directly from a ParsePrimaryKey method, or as a RouteOptions.KeyParser
+
+func ParseInt64PrimaryKey(rawKey string) (interface{}, error) {
+	return strconv.ParseInt(rawKey, 10, 64)
+}
+
+func ParseUint64PrimaryKey(rawKey string) (interface{}, error) {
+	return strconv.ParseUint(rawKey, 10, 64)
+}
+
+// in hex digits, 8-4-4-4-12
+var uuidGroupLengths = []int{8, 4, 4, 4, 12}
+
+// Accepts the canonical 8-4-4-4-12 hex form, in either case.  returns a UUID
+func ParseUUIDPrimaryKey(rawKey string) (interface{}, error) {
+	var uuid UUID
+	// each group on its own, so that a misplaced dash is an error rather than a different UUID
+	groups := strings.Split(rawKey, "-")
+	if len(groups) != len(uuidGroupLengths) {
+		return nil, errors.New("invalid UUID format")
+	}
+	uuidIndex := 0
+	for i, group := range groups {
+		if len(group) != uuidGroupLengths[i] {
+			return nil, errors.New("invalid UUID format")
+		}
+		n, err := hex.Decode(uuid[uuidIndex:], []byte(group))
+		if err != nil {
+			return nil, err
+		}
+		uuidIndex += n
+	}
+	return uuid, nil
+}
+
+// Parses ctx.Endpoint.PrimaryKey w/ the route's KeyParser, if any.  returns false if a 400 has been sent.
+func validatePrimaryKey(ctx *Context, routePtr *Route) bool {
+	if routePtr.KeyParser == nil || ctx.Endpoint.PrimaryKey == "" {
+		return true
+	}
+	typedKey, err := routePtr.KeyParser(ctx.Endpoint.PrimaryKey)
+	if err != nil {
+		errInfo := ErrorInfo{
+			ErrorNumber:  BadRequestMalformedPrimaryKeyErrorNumber,
+			ErrorMessage: BadRequestMalformedPrimaryKeyPrefix,
+			DebugMessage: err.Error(),
+		}
+		ctx.SendErrorInfoPayload(http.StatusBadRequest, errInfo)
+		return false
+	}
+	ctx.Endpoint.typedPrimaryKey = typedKey
+	return true
+}
+
+// The primary key as parsed by the controller's PrimaryKeyParser (or RouteOptions.KeyParser).
+// nil if there is no parser or no primary key.
+func (e *Endpoint) TypedPrimaryKey() interface{} {
+	return e.typedPrimaryKey
+}
+
+// ok is false if the primary key wasn't parsed by ParseInt64PrimaryKey (or a custom parser returning an int64)
+func (e *Endpoint) PrimaryKeyInt64() (key int64, ok bool) {
+	key, ok = e.typedPrimaryKey.(int64)
+	return key, ok
+}
+
+// ok is false if the primary key wasn't parsed by ParseUint64PrimaryKey (or a custom parser returning a uint64)
+func (e *Endpoint) PrimaryKeyUint64() (key uint64, ok bool) {
+	key, ok = e.typedPrimaryKey.(uint64)
+	return key, ok
+}
+
+// ok is false if the primary key wasn't parsed by ParseUUIDPrimaryKey (or a custom parser returning a UUID)
+func (e *Endpoint) PrimaryKeyUUID() (key UUID, ok bool) {
+	key, ok = e.typedPrimaryKey.(UUID)
+	return key, ok
+}
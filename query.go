@@ -0,0 +1,262 @@
+package eprouter
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amattn/deeperror"
+)
+
+// Query parameter binding
+//
+//	type BookQuery struct {
+//		Author string    `query:"author,required"`
+//		Limit  int       `query:"limit"`
+//		Tags   []string  `query:"tag"`                       // ?tag=a&tag=b or ?tag=a,b
+//		Since  time.Time `query:"since" layout:"2006-01-02"` // layout defaults to time.RFC3339
+//		Signed *bool     `query:"signed"`                    // pointers are left nil when absent
+//	}
+//
+//	var query BookQuery
+//	if err := ctx.BindQuery(&query); err != nil {
+//		return ctx.MakeRouteHandlerResultFromError(err)
+//	}
+//
+// Only tagged fields are bound.  Supported types are string, bool, ints, uints, floats, time.Time, time.Duration,
+// pointers to any of those, and slices of any of those.
+// The reflection happens once per type; bindings are cached.
+
+const (
+	BadRequestInvalidQueryErrorNumber = 4000000005
+	BadRequestInvalidQueryPrefix      = BadRequestPrefix + ": Invalid Query Parameters"
+)
+
+// One offending parameter (or body field) of a 400 response
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+type queryField struct {
+	index    int
+	name     string
+	required bool
+	isSlice  bool
+	isPtr    bool
+	elemType reflect.Type
+	convert  func(rawValue string) (reflect.Value, error)
+}
+
+type queryBinding struct {
+	fields []queryField
+}
+
+var queryBindingCache sync.Map // key is reflect.Type of the struct, value is *queryBinding
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+)
+
+// Binds ctx.Req.URL.Query() into dst, which must be a pointer to a struct.
+// returns a *RouteError (400 w/ every offending parameter in ErrorInfo.FieldErrors) on bad input,
+// or a *deeperror.DeepError (500) if dst isn't bindable.
+func (ctx *Context) BindQuery(dst interface{}) error {
+	dstValue := reflect.ValueOf(dst)
+	if dstValue.Kind() != reflect.Ptr || dstValue.IsNil() || dstValue.Elem().Kind() != reflect.Struct {
+		return deeperror.NewHTTPError(3910257746, InternalServerErrorPrefix, fmt.Errorf("BindQuery expects a pointer to a struct, got %T", dst), http.StatusInternalServerError)
+	}
+
+	binding, err := queryBindingFor(dstValue.Elem().Type())
+	if err != nil {
+		return deeperror.NewHTTPError(3910257747, InternalServerErrorPrefix, err, http.StatusInternalServerError)
+	}
+
+	fieldErrors := binding.bind(ctx.Req.URL.Query(), dstValue.Elem())
+	if len(fieldErrors) > 0 {
+		return NewRouteError(http.StatusBadRequest, ErrorInfo{
+			ErrorNumber:  BadRequestInvalidQueryErrorNumber,
+			ErrorMessage: BadRequestInvalidQueryPrefix,
+			FieldErrors:  fieldErrors,
+		})
+	}
+	return nil
+}
+
+func queryBindingFor(structType reflect.Type) (*queryBinding, error) {
+	if cached, exists := queryBindingCache.Load(structType); exists {
+		return cached.(*queryBinding), nil
+	}
+	binding, err := makeQueryBinding(structType)
+	if err != nil {
+		return nil, err
+	}
+	// if two requests race here, both bindings are identical, so it doesn't matter who wins
+	queryBindingCache.Store(structType, binding)
+	return binding, nil
+}
+
+func makeQueryBinding(structType reflect.Type) (*queryBinding, error) {
+	binding := new(queryBinding)
+	for i := 0; i < structType.NumField(); i++ {
+		structField := structType.Field(i)
+		tag := structField.Tag.Get("query")
+		if tag == "" || tag == "-" || structField.PkgPath != "" {
+			// untagged or unexported
+			continue
+		}
+
+		tagParts := strings.Split(tag, ",")
+		field := queryField{index: i, name: tagParts[0]}
+		for _, option := range tagParts[1:] {
+			if option == "required" {
+				field.required = true
+			}
+		}
+
+		fieldType := structField.Type
+		if fieldType.Kind() == reflect.Slice {
+			field.isSlice = true
+			fieldType = fieldType.Elem()
+		} else if fieldType.Kind() == reflect.Ptr {
+			field.isPtr = true
+			fieldType = fieldType.Elem()
+		}
+		field.elemType = fieldType
+
+		convert, err := makeQueryConverter(fieldType, structField.Tag.Get("layout"))
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %s", structType, structField.Name, err)
+		}
+		field.convert = convert
+		binding.fields = append(binding.fields, field)
+	}
+	return binding, nil
+}
+
+func makeQueryConverter(fieldType reflect.Type, layout string) (func(string) (reflect.Value, error), error) {
+	switch {
+	case fieldType == timeType:
+		if layout == "" {
+			layout = time.RFC3339
+		}
+		return func(rawValue string) (reflect.Value, error) {
+			parsed, err := time.Parse(layout, rawValue)
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("expected a time formatted as %s", layout)
+			}
+			return reflect.ValueOf(parsed), nil
+		}, nil
+	case fieldType == durationType:
+		return func(rawValue string) (reflect.Value, error) {
+			parsed, err := time.ParseDuration(rawValue)
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("expected a duration, eg: 1h30m")
+			}
+			return reflect.ValueOf(parsed), nil
+		}, nil
+	}
+
+	switch fieldType.Kind() {
+	case reflect.String:
+		return func(rawValue string) (reflect.Value, error) {
+			return reflect.ValueOf(rawValue).Convert(fieldType), nil
+		}, nil
+	case reflect.Bool:
+		return func(rawValue string) (reflect.Value, error) {
+			parsed, err := strconv.ParseBool(rawValue)
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("expected true or false")
+			}
+			return reflect.ValueOf(parsed).Convert(fieldType), nil
+		}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		bitSize := fieldType.Bits()
+		return func(rawValue string) (reflect.Value, error) {
+			parsed, err := strconv.ParseInt(rawValue, 10, bitSize)
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("expected an integer (%d bit)", bitSize)
+			}
+			return reflect.ValueOf(parsed).Convert(fieldType), nil
+		}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		bitSize := fieldType.Bits()
+		return func(rawValue string) (reflect.Value, error) {
+			parsed, err := strconv.ParseUint(rawValue, 10, bitSize)
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("expected a non-negative integer (%d bit)", bitSize)
+			}
+			return reflect.ValueOf(parsed).Convert(fieldType), nil
+		}, nil
+	case reflect.Float32, reflect.Float64:
+		bitSize := fieldType.Bits()
+		return func(rawValue string) (reflect.Value, error) {
+			parsed, err := strconv.ParseFloat(rawValue, bitSize)
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("expected a number")
+			}
+			return reflect.ValueOf(parsed).Convert(fieldType), nil
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported query field type %s", fieldType)
+}
+
+// every offending parameter, not just the first
+func (binding *queryBinding) bind(values map[string][]string, structValue reflect.Value) []FieldError {
+	fieldErrors := []FieldError{}
+	for _, field := range binding.fields {
+		rawValues := values[field.name]
+		if field.isSlice {
+			// repeated (?tag=a&tag=b) and comma-separated (?tag=a,b) are equivalent
+			split := []string{}
+			for _, rawValue := range rawValues {
+				for _, part := range strings.Split(rawValue, ",") {
+					if part != "" {
+						split = append(split, part)
+					}
+				}
+			}
+			rawValues = split
+		}
+
+		if len(rawValues) == 0 || (field.isSlice == false && rawValues[0] == "") {
+			if field.required {
+				fieldErrors = append(fieldErrors, FieldError{field.name, "required"})
+			}
+			continue
+		}
+
+		fieldValue := structValue.Field(field.index)
+		switch {
+		case field.isSlice:
+			sliceValue := reflect.MakeSlice(fieldValue.Type(), 0, len(rawValues))
+			for _, rawValue := range rawValues {
+				converted, err := field.convert(rawValue)
+				if err != nil {
+					fieldErrors = append(fieldErrors, FieldError{field.name, fmt.Sprintf("%q: %s", rawValue, err)})
+					continue
+				}
+				sliceValue = reflect.Append(sliceValue, converted)
+			}
+			fieldValue.Set(sliceValue)
+		default:
+			converted, err := field.convert(rawValues[0])
+			if err != nil {
+				fieldErrors = append(fieldErrors, FieldError{field.name, fmt.Sprintf("%q: %s", rawValues[0], err)})
+				continue
+			}
+			if field.isPtr {
+				ptrValue := reflect.New(field.elemType)
+				ptrValue.Elem().Set(converted)
+				converted = ptrValue
+			}
+			fieldValue.Set(converted)
+		}
+	}
+	return fieldErrors
+}
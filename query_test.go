@@ -0,0 +1,113 @@
+package eprouter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type bookQuery struct {
+	Author   string        `query:"author,required"`
+	Limit    int           `query:"limit"`
+	Offset   uint16        `query:"offset"`
+	Tags     []string      `query:"tag"`
+	IDs      []int64       `query:"id"`
+	Since    time.Time     `query:"since" layout:"2006-01-02"`
+	Signed   *bool         `query:"signed"`
+	Timeout  time.Duration `query:"timeout"`
+	Ratio    float64       `query:"ratio"`
+	Ignored  string
+	internal string `query:"internal"`
+}
+
+func bindQueryForTesting(t *testing.T, rawQuery string, dst interface{}) error {
+	ctx := new(Context)
+	ctx.Req = httptest.NewRequest("GET", "/api/v1/book/?"+rawQuery, nil)
+	return ctx.BindQuery(dst)
+}
+
+func TestBindQuery(t *testing.T) {
+	var query bookQuery
+	err := bindQueryForTesting(t, "author=amattn&limit=-5&offset=10&tag=a&tag=b,c&id=1,2&since=2026-10-16&signed=true&timeout=1m30s&ratio=0.5&Ignored=x&internal=x", &query)
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	signed := true
+	expected := bookQuery{
+		Author:  "amattn",
+		Limit:   -5,
+		Offset:  10,
+		Tags:    []string{"a", "b", "c"},
+		IDs:     []int64{1, 2},
+		Since:   time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC),
+		Signed:  &signed,
+		Timeout: 90 * time.Second,
+		Ratio:   0.5,
+	}
+	if reflect.DeepEqual(query, expected) == false {
+		t.Errorf("expected %+v, got %+v", expected, query)
+	}
+
+	// absent optional fields are left alone
+	query = bookQuery{Limit: 20}
+	if err := bindQueryForTesting(t, "author=amattn", &query); err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if query.Limit != 20 || query.Signed != nil || query.Tags != nil {
+		t.Errorf("expected defaults to be kept, got %+v", query)
+	}
+
+	// every offending parameter is reported
+	err = bindQueryForTesting(t, "limit=lots&offset=70000&id=1,x&since=yesterday&signed=maybe", &query)
+	rerr, ok := err.(*RouteError)
+	if ok == false {
+		t.Fatalf("expected *RouteError, got %T %v", err, err)
+	}
+	if rerr.statusCode != http.StatusBadRequest || rerr.errorInfo.ErrorNumber != BadRequestInvalidQueryErrorNumber {
+		t.Error("expected 400 w/ BadRequestInvalidQueryErrorNumber, got", rerr)
+	}
+	offenders := map[string]bool{}
+	for _, fieldError := range rerr.errorInfo.FieldErrors {
+		offenders[fieldError.Field] = true
+	}
+	for _, name := range []string{"author", "limit", "offset", "id", "since", "signed"} {
+		if offenders[name] == false {
+			t.Error("expected field error for", name, "got", rerr.errorInfo.FieldErrors)
+		}
+	}
+	if len(rerr.errorInfo.FieldErrors) != 6 {
+		t.Error("expected 6 field errors, got", rerr.errorInfo.FieldErrors)
+	}
+
+	// programmer errors
+	if err := bindQueryForTesting(t, "", query); err == nil {
+		t.Error("expected error for non-pointer")
+	}
+	unsupported := struct {
+		Things map[string]string `query:"things"`
+	}{}
+	if err := bindQueryForTesting(t, "", &unsupported); err == nil {
+		t.Error("expected error for unsupported field type")
+	}
+
+	// cached per type
+	if _, cached := queryBindingCache.Load(reflect.TypeOf(bookQuery{})); cached == false {
+		t.Error("expected binding to be cached")
+	}
+}
+
+// The binding plan is cached, so per request it's just conversion.
+func BenchmarkBindQuery(b *testing.B) {
+	ctx := new(Context)
+	ctx.Req = httptest.NewRequest("GET", "/api/v1/book/?author=amattn&limit=5&tag=a,b", nil)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var query bookQuery
+		if err := ctx.BindQuery(&query); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package eprouter
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/amattn/deeperror"
+)
+
+// Every problem found while registering a controller (or a single route), aggregated into one error.
+type RegistrationError struct {
+	EntityName     string
+	ControllerName string
+	Problems       []*deeperror.DeepError
+}
+
+func newRegistrationError(entityName, controllerName string) *RegistrationError {
+	regErr := new(RegistrationError)
+	regErr.EntityName = entityName
+	regErr.ControllerName = controllerName
+	return regErr
+}
+
+func (regErr *RegistrationError) add(derr *deeperror.DeepError) {
+	regErr.Problems = append(regErr.Problems, derr)
+}
+
+func (regErr *RegistrationError) hasProblems() bool {
+	return len(regErr.Problems) > 0
+}
+
+func (regErr *RegistrationError) Error() string {
+	lines := make([]string, 0, len(regErr.Problems)+1)
+	lines = append(lines, fmt.Sprintf("%d registration problem(s) for entity '%s' controller '%s':", len(regErr.Problems), regErr.EntityName, regErr.ControllerName))
+	for _, derr := range regErr.Problems {
+		lines = append(lines, fmt.Sprintf("\t%d %s", derr.Num, derr.EndUserMsg))
+	}
+	return strings.Join(lines, "\n")
+}
+
+type ConflictPolicy int
+
+const (
+	ConflictPolicyWarn     ConflictPolicy = iota // log a warning, the last registered route wins
+	ConflictPolicyError                          // refuse to register the conflicting route, returning a *RegistrationError
+	ConflictPolicyLastWins                       // silently let the last registered route win
+)
+
+// Two handlers which resolve to the same method, version, entity and action.
+type RouteConflict struct {
+	Existing    *Route
+	Replacement *Route
+}
+
+func (conflict RouteConflict) String() string {
+	return fmt.Sprintf("%s v%s %s action:'%s' %s %s conflicts with %s %s",
+		conflict.Existing.Method,
+		conflict.Existing.VersionStr,
+		conflict.Existing.EntityPath(),
+		conflict.Existing.Action,
+		conflict.Existing.ControllerName,
+		conflict.Existing.HandlerName,
+		conflict.Replacement.ControllerName,
+		conflict.Replacement.HandlerName,
+	)
+}
+
+func controllerNameOf(payloadController PayloadController) string {
+	if payloadController == nil {
+		return "<nil>"
+	}
+	return reflect.TypeOf(payloadController).String()
+}
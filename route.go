@@ -1,27 +1,54 @@
 package eprouter
 
 import (
+	"fmt"
 	"log"
+	"reflect"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/amattn/deeperror"
 )
 
 const (
-	MAGIC_AUTH_REQUIRED_PREFIX  = "Auth"
-	MAGIC_HANDLER_KEYWORD       = "Handler"
-	MAGIC_GET_HANDLER_PREFIX    = "GetHandler"    // CRUD: read
-	MAGIC_POST_HANDLER_PREFIX   = "PostHandler"   // CRUD: create
-	MAGIC_PUT_HANDLER_PREFIX    = "PutHandler"    // CRUD: update (the whole thing)
-	MAGIC_PATCH_HANDLER_PREFIX  = "PatchHandler"  // CRUD: update (just a field or two)
-	MAGIC_DELETE_HANDLER_PREFIX = "DeleteHandler" // CRUD: delete (duh)
-	MAGIC_HEAD_HANDLER_PREFIX   = "HeadHandler"   // usually when you just want to check Etags or something.
+	MAGIC_AUTH_REQUIRED_PREFIX   = "Auth"
+	MAGIC_HANDLER_KEYWORD        = "Handler"
+	MAGIC_GET_HANDLER_PREFIX     = "GetHandler"     // CRUD: read
+	MAGIC_LIST_HANDLER_PREFIX    = "ListHandler"    // CRUD: read the collection (GET w/o a primary key)
+	MAGIC_POST_HANDLER_PREFIX    = "PostHandler"    // CRUD: create
+	MAGIC_PUT_HANDLER_PREFIX     = "PutHandler"     // CRUD: update (the whole thing)
+	MAGIC_PATCH_HANDLER_PREFIX   = "PatchHandler"   // CRUD: update (just a field or two)
+	MAGIC_DELETE_HANDLER_PREFIX  = "DeleteHandler"  // CRUD: delete (duh)
+	MAGIC_HEAD_HANDLER_PREFIX    = "HeadHandler"    // usually when you just want to check Etags or something.
+	MAGIC_OPTIONS_HANDLER_PREFIX = "OptionsHandler" // optional, OPTIONS is answered automatically w/ an Allow header otherwise
 )
 
 const VERSION_BIT_DEPTH = 16
 
 type VersionUint uint16
 
+// Whether a route expects a primary key in the path (eg: /v1/book/3 vs /v1/book/)
+// The router answers 400 w/ BadRequestMissingPrimaryKeyErrorNumber or BadRequestExtraneousPrimaryKeyErrorNumber itself.
+type PrimaryKeyPolicy int
+
+const (
+	PrimaryKeyOptional  PrimaryKeyPolicy = iota // the default, the handler sorts it out
+	PrimaryKeyRequired                          // item routes, eg: GetHandlerV1 alongside a ListHandlerV1
+	PrimaryKeyForbidden                         // collection routes, eg: ListHandlerV1 or PostHandlerV1
+)
+
+func (policy PrimaryKeyPolicy) String() string {
+	switch policy {
+	case PrimaryKeyRequired:
+		return "required"
+	case PrimaryKeyForbidden:
+		return "forbidden"
+	}
+	return "optional"
+}
+
 type Route struct {
 	RequiresAuth  bool
 	Authenticator AuthHandler
@@ -34,6 +61,82 @@ type Route struct {
 	Handler        RouteHandler
 	HandlerName    string // not actually used except for logging and debugging
 	ControllerName string // not actually used except for logging and debugging
+
+	ParentEntityNames []string // outermost first. empty for top-level entities
+
+	KeyPolicy PrimaryKeyPolicy
+	KeyParser PrimaryKeyParseFunc // optional, see PrimaryKeyParser
+
+	// optional.  if > 0, the handler's std context is cancelled after Timeout, and the request answered w/ a 504
+	// if the handler hasn't finished by then.  see RouteTimeoutProvider
+	// The handler keeps running until it returns, so it must stop once ctx.StdContext() is done.
+	// Its reads of the request body fail from then on.  The whole response is buffered, so it can't be streamed.
+	Timeout time.Duration
+
+	// optional.  overrides Router.MaxBodyBytes for this route, < 0 is unlimited.  see RouteMaxBodyBytesProvider
+	MaxBodyBytes int64
+
+	Metadata map[string]string // optional, free-form.  surfaced via Router.Routes() (eg: for docs or gateway tooling)
+
+	Deprecation *Deprecation // optional, overrides any version or entity level deprecation.  see Router.DeprecateRoute
+}
+
+// Optional settings for routes registered via Router.Handle
+type RouteOptions struct {
+	RequiresAuth  bool
+	Authenticator AuthHandler // required if RequiresAuth is true
+
+	HandlerName    string // optional, used for logging and debugging.  defaults to the function name
+	ControllerName string // optional, used for logging and debugging
+
+	// optional, defaults to PrimaryKeyOptional.  A PrimaryKeyForbidden (collection) route can share a path w/ an item route.
+	KeyPolicy PrimaryKeyPolicy
+	KeyParser PrimaryKeyParseFunc // optional, rejects malformed primary keys w/ a 400.  eg: ParseInt64PrimaryKey
+
+	Timeout      time.Duration // optional, see Route.Timeout
+	MaxBodyBytes int64         // optional, see Route.MaxBodyBytes
+
+	Metadata map[string]string // optional, surfaced via Router.Routes()
+
+	Deprecation *Deprecation // optional, see Router.DeprecateRoute
+}
+
+// eg: "author/book" for a book nested under author, just "book" for a top-level entity
+func (route *Route) EntityPath() string {
+	return joinEntityPath(route.ParentEntityNames, route.EntityName)
+}
+
+// 0 for unversioned routes
+func (route *Route) version() VersionUint {
+	v64, _ := strconv.ParseUint(route.VersionStr, 10, VERSION_BIT_DEPTH)
+	return VersionUint(v64)
+}
+
+// eg: /api/v1/author/:author/book/popular or /api/healthz
+func (route *Route) fullPath(basePath string) string {
+	if route.VersionStr == "" {
+		return basePath + route.Path
+	}
+	return basePath + "v" + route.VersionStr + "/" + route.Path
+}
+
+// eg: "author/:author/" for a book nested under author, "" for a top-level entity
+func (route *Route) parentsPath() string {
+	path := ""
+	for _, parentName := range route.ParentEntityNames {
+		path += parentName + "/:" + parentName + "/"
+	}
+	return path
+}
+
+func joinEntityPath(parentNames []string, entityName string) string {
+	if len(parentNames) == 0 {
+		return entityName
+	}
+	if entityName == "" {
+		return strings.Join(parentNames, "/")
+	}
+	return strings.Join(parentNames, "/") + "/" + entityName
 }
 
 func parseVersionFromPrefixlessHandlerName(versionActionHandlerName string) (vStr string, action string) {
@@ -61,6 +164,60 @@ func parseVersionFromPrefixlessHandlerName(versionActionHandlerName string) (vSt
 	return
 }
 
+// Parses the magic handler name: [Auth]<Method>HandlerV<version><Action>
+// returns a non-nil skipped error (w/ the reason) if the name can't be routed.
+// keyPolicy is PrimaryKeyForbidden for ListHandlers, see requirePrimaryKeysAlongsideCollections for the rest
+func parseHandlerName(handlerName string) (requiresAuth bool, method, versionStr, action string, keyPolicy PrimaryKeyPolicy, skipped *deeperror.DeepError) {
+	// Step 1 Check for Auth prrefix
+	deauthedHandlerName := handlerName
+	if strings.HasPrefix(handlerName, MAGIC_AUTH_REQUIRED_PREFIX) {
+		deauthedHandlerName = handlerName[len(MAGIC_AUTH_REQUIRED_PREFIX):]
+		requiresAuth = true
+	}
+
+	// step 2 Find method
+	var versionActionHandlerName string
+	switch {
+	case strings.HasPrefix(deauthedHandlerName, MAGIC_GET_HANDLER_PREFIX):
+		method = "GET"
+		versionActionHandlerName = deauthedHandlerName[len(MAGIC_GET_HANDLER_PREFIX):]
+	case strings.HasPrefix(deauthedHandlerName, MAGIC_LIST_HANDLER_PREFIX):
+		method = "GET"
+		keyPolicy = PrimaryKeyForbidden
+		versionActionHandlerName = deauthedHandlerName[len(MAGIC_LIST_HANDLER_PREFIX):]
+	case strings.HasPrefix(deauthedHandlerName, MAGIC_POST_HANDLER_PREFIX):
+		method = "POST"
+		versionActionHandlerName = deauthedHandlerName[len(MAGIC_POST_HANDLER_PREFIX):]
+	case strings.HasPrefix(deauthedHandlerName, MAGIC_PUT_HANDLER_PREFIX):
+		method = "PUT"
+		versionActionHandlerName = deauthedHandlerName[len(MAGIC_PUT_HANDLER_PREFIX):]
+	case strings.HasPrefix(deauthedHandlerName, MAGIC_DELETE_HANDLER_PREFIX):
+		method = "DELETE"
+		versionActionHandlerName = deauthedHandlerName[len(MAGIC_DELETE_HANDLER_PREFIX):]
+	case strings.HasPrefix(deauthedHandlerName, MAGIC_PATCH_HANDLER_PREFIX):
+		method = "PATCH"
+		versionActionHandlerName = deauthedHandlerName[len(MAGIC_PATCH_HANDLER_PREFIX):]
+	case strings.HasPrefix(deauthedHandlerName, MAGIC_HEAD_HANDLER_PREFIX):
+		method = "HEAD"
+		versionActionHandlerName = deauthedHandlerName[len(MAGIC_HEAD_HANDLER_PREFIX):]
+	case strings.HasPrefix(deauthedHandlerName, MAGIC_OPTIONS_HANDLER_PREFIX):
+		method = "OPTIONS"
+		versionActionHandlerName = deauthedHandlerName[len(MAGIC_OPTIONS_HANDLER_PREFIX):]
+	default:
+		// skip... it's not a known prefix
+		return false, "", "", "", PrimaryKeyOptional, deeperror.New(1860816435, "Skipping Route: unknown method prefix in "+handlerName, nil)
+	}
+
+	// do a bit of primite parsing:
+	versionStr, action = parseVersionFromPrefixlessHandlerName(versionActionHandlerName)
+	if versionStr == "" {
+		// skip... invalid prefix
+		return false, "", "", "", PrimaryKeyOptional, deeperror.New(1259486570, "Skipping Route: cannot parse V<#><Action> in "+handlerName, nil)
+	}
+
+	return requiresAuth, method, versionStr, action, keyPolicy, nil
+}
+
 // Validation
 
 func ValidateEntityName(name string) (isValid bool, reason string) {
@@ -70,20 +227,68 @@ func ValidateEntityName(name string) (isValid bool, reason string) {
 	// TODO: check for valid url chars: [a-Z 0-9 _ -]
 	return true, ""
 }
+
+// An HTTP method is a token (RFC 7230), eg: GET or PROPFIND
+func ValidateMethod(method string) (isValid bool, reason string) {
+	if len(method) < 1 {
+		return false, "method must have at least one character"
+	}
+	for i := 0; i < len(method); i++ {
+		if isTokenChar(method[i]) == false {
+			return false, fmt.Sprintf("method must be a token, invalid character %q", method[i])
+		}
+	}
+	return true, ""
+}
+
+func isTokenChar(c byte) bool {
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') || strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0
+}
+
 func ValidateHandlerName(handler interface{}) (isValid bool, reason string) {
 	// TODO length? not much here really.
 	return true, ""
 }
+
+// Accepts any of the following function types, and converts them to a RouteHandler:
+//
+//	func(*Context) RouteHandlerResult
+//	func(*Context) (Payload, error)
+//	func(*Context) ([]Payload, error)
+//	func(*Context, *T) RouteHandlerResult
+//	func(*Context, *T) (Payload, error)
+//	func(*Context, *T) ([]Payload, error)
+//
+// where *T is a pointer to the type the request body is decoded into.
+// All type checks happen here, the resulting RouteHandler calls the handler w/o reflection.
 func ValidateHandler(unknownHandler interface{}) (isValid bool, reason string, handler RouteHandler) {
 
 	// We have to do some type gymnastics here.  first check the if the method matches the raw function type...
-	validHandler, ok := unknownHandler.(func(*Context) RouteHandlerResult)
-
-	if ok == false {
-		return false, "wrong function type, expected function type of RouteHandler", nil
+	switch validHandler := unknownHandler.(type) {
+	case func(*Context) RouteHandlerResult:
+		// ...then convert the raw funtion type to the typed RouteHandler
+		return true, "", validHandler
+	case func(*Context) (Payload, error):
+		return true, "", wrapPayloadHandler(validHandler)
+	case func(*Context) ([]Payload, error):
+		return true, "", wrapPayloadsHandler(validHandler)
+	case controllerMethod:
+		// from RegisterEntity, any of the above w/ a controller receiver
+		sig, reason := checkHandlerSignature(validHandler.method.Type, 1)
+		if reason != "" {
+			return false, reason, nil
+		}
+		return true, "", makeTypedHandler(validHandler.method.Func.Interface(), sig, true, validHandler.receiver)
 	}
 
-	// ...then convert the raw funtion type to the typed RouteHandler
-	handler = validHandler
-	return true, "", handler
+	// ...if it isn't one of the simple types, it may be a body binding handler.
+	sig, reason := checkHandlerSignature(reflect.TypeOf(unknownHandler), 0)
+	if reason == "" && sig.bodyPtrType == nil {
+		// eg: a named func type
+		reason = wrongHandlerTypeReason
+	}
+	if reason != "" {
+		return false, reason, nil
+	}
+	return true, "", makeTypedHandler(unknownHandler, sig, false, nil)
 }
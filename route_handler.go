@@ -1,6 +1,16 @@
 package eprouter
 
-import "github.com/amattn/deeperror/levels"
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"unsafe"
+
+	"github.com/amattn/deeperror"
+	"github.com/amattn/deeperror/levels"
+)
 
 type RouteHandler func(*Context) RouteHandlerResult
 
@@ -33,3 +43,308 @@ func NewRouteError(code int, errInfo ErrorInfo) *RouteError {
 	rerr.errorInfo = errInfo
 	return rerr
 }
+
+// So that handlers with typed signatures can return a *RouteError as an error
+func (rerr *RouteError) Error() string {
+	return fmt.Sprintf("%d %d %s", rerr.statusCode, rerr.errorInfo.ErrorNumber, rerr.errorInfo.ErrorMessage)
+}
+
+// Typed Handlers
+
+// Handler signatures are checked w/ reflect once, at registration.  Requests don't go through reflect at all:
+// handlers are called via the func types below, which declare every pointer parameter (the controller, the *Context
+// and the *T request body) as an unsafe.Pointer.  Pointers of any type are passed alike, so such a call is the
+// same call the compiler would make for the declared signature.
+
+var (
+	contextPtrType = reflect.TypeOf((*Context)(nil))
+	resultType     = reflect.TypeOf(RouteHandlerResult{})
+	payloadType    = reflect.TypeOf((*Payload)(nil)).Elem()
+	payloadsType   = reflect.TypeOf([]Payload(nil))
+	errorType      = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+const wrongHandlerTypeReason = "wrong function type, expected function type of RouteHandler, func(*Context[, *T]) (Payload, error) or func(*Context[, *T]) ([]Payload, error)"
+
+func wrapPayloadHandler(typedHandler func(*Context) (Payload, error)) RouteHandler {
+	return func(ctx *Context) RouteHandlerResult {
+		payload, err := typedHandler(ctx)
+		return makeTypedHandlerResult(ctx, payload, nil, err)
+	}
+}
+
+func wrapPayloadsHandler(typedHandler func(*Context) ([]Payload, error)) RouteHandler {
+	return func(ctx *Context) RouteHandlerResult {
+		payloads, err := typedHandler(ctx)
+		return makeTypedHandlerResult(ctx, nil, payloads, err)
+	}
+}
+
+// What a typed handler returns
+type handlerResults int
+
+const (
+	returnsResult   handlerResults = iota // RouteHandlerResult
+	returnsPayload                        // (Payload, error)
+	returnsPayloads                       // ([]Payload, error)
+)
+
+// A checked func(*Context[, *T]) (RouteHandlerResult | (Payload, error) | ([]Payload, error))
+type handlerSignature struct {
+	bodyPtrType reflect.Type // *T, nil if the handler doesn't bind a request body
+	results     handlerResults
+}
+
+// skip is the number of leading parameters to ignore, 1 for the receiver of a method expression.
+// returns a reason if fnType doesn't match.
+func checkHandlerSignature(fnType reflect.Type, skip int) (sig handlerSignature, reason string) {
+	if fnType == nil || fnType.Kind() != reflect.Func {
+		return sig, wrongHandlerTypeReason
+	}
+	numIn := fnType.NumIn() - skip
+	if numIn < 1 || numIn > 2 || fnType.In(skip) != contextPtrType {
+		return sig, wrongHandlerTypeReason
+	}
+	if numIn == 2 {
+		if fnType.In(skip+1).Kind() != reflect.Ptr {
+			return sig, "wrong function type, request body parameter must be a pointer, got " + fnType.In(skip+1).String()
+		}
+		sig.bodyPtrType = fnType.In(skip + 1)
+	}
+
+	switch {
+	case fnType.NumOut() == 1 && fnType.Out(0) == resultType:
+		sig.results = returnsResult
+	case fnType.NumOut() == 2 && fnType.Out(1) == errorType && fnType.Out(0) == payloadType:
+		sig.results = returnsPayload
+	case fnType.NumOut() == 2 && fnType.Out(1) == errorType && fnType.Out(0) == payloadsType:
+		sig.results = returnsPayloads
+	default:
+		return sig, wrongHandlerTypeReason
+	}
+	return sig, ""
+}
+
+// A controller's method as found by RegisterEntity, not yet bound to the controller.  see ValidateHandler
+type controllerMethod struct {
+	receiver unsafe.Pointer // a pointer to the controller, see controllerReceiver
+	method   reflect.Method // of the receiver's type, so method.Func takes the receiver as its first parameter
+}
+
+// A pointer to the controller, so that every one of its methods takes the same pointer receiver.
+// Controllers which aren't pointers are copied, as calling one of their (value) methods would.
+func controllerReceiver(payloadController PayloadController) (receiver unsafe.Pointer, receiverType reflect.Type) {
+	controllerType := reflect.TypeOf(payloadController)
+	if controllerType.Kind() == reflect.Ptr {
+		return interfaceWord(payloadController), controllerType
+	}
+	copied := reflect.New(controllerType)
+	copied.Elem().Set(reflect.ValueOf(payloadController))
+	return unsafe.Pointer(copied.Pointer()), copied.Type()
+}
+
+func newControllerMethod(receiver unsafe.Pointer, receiverType reflect.Type, methodName string) controllerMethod {
+	method, _ := receiverType.MethodByName(methodName)
+	return controllerMethod{receiver, method}
+}
+
+// The layout of an interface{}
+type emptyInterface struct {
+	typ  unsafe.Pointer
+	word unsafe.Pointer
+}
+
+// The data word of i.  For a func, the func value itself.
+func interfaceWord(i interface{}) unsafe.Pointer {
+	return (*emptyInterface)(unsafe.Pointer(&i)).word
+}
+
+// Builds the RouteHandler for a checked signature.  fn is a func(*Context[, *T]) or, if isMethod,
+// a method expression taking receiver first.
+func makeTypedHandler(fn interface{}, sig handlerSignature, isMethod bool, receiver unsafe.Pointer) RouteHandler {
+	fnWord := interfaceWord(fn)
+	var decodeBody func(*Context) (unsafe.Pointer, error)
+	if sig.bodyPtrType != nil {
+		decodeBody = makeBodyDecoder(sig.bodyPtrType)
+	}
+
+	switch {
+	case isMethod && decodeBody != nil:
+		call := pointerCall3(fnWord, sig.results)
+		return func(ctx *Context) RouteHandlerResult {
+			body, err := decodeBody(ctx)
+			if err != nil {
+				return ctx.MakeRouteHandlerResultFromError(err)
+			}
+			return call(ctx, receiver, unsafe.Pointer(ctx), body)
+		}
+	case isMethod:
+		call := pointerCall2(fnWord, sig.results)
+		return func(ctx *Context) RouteHandlerResult {
+			return call(ctx, receiver, unsafe.Pointer(ctx))
+		}
+	case decodeBody != nil:
+		call := pointerCall2(fnWord, sig.results)
+		return func(ctx *Context) RouteHandlerResult {
+			body, err := decodeBody(ctx)
+			if err != nil {
+				return ctx.MakeRouteHandlerResultFromError(err)
+			}
+			return call(ctx, unsafe.Pointer(ctx), body)
+		}
+	}
+	// func(*Context) signatures are matched by type, see ValidateHandler
+	return nil
+}
+
+// fnWord called w/ 2 pointer parameters
+func pointerCall2(fnWord unsafe.Pointer, results handlerResults) func(ctx *Context, first, second unsafe.Pointer) RouteHandlerResult {
+	switch results {
+	case returnsPayload:
+		typedFn := *(*func(unsafe.Pointer, unsafe.Pointer) (Payload, error))(unsafe.Pointer(&fnWord))
+		return func(ctx *Context, first, second unsafe.Pointer) RouteHandlerResult {
+			payload, err := typedFn(first, second)
+			return makeTypedHandlerResult(ctx, payload, nil, err)
+		}
+	case returnsPayloads:
+		typedFn := *(*func(unsafe.Pointer, unsafe.Pointer) ([]Payload, error))(unsafe.Pointer(&fnWord))
+		return func(ctx *Context, first, second unsafe.Pointer) RouteHandlerResult {
+			payloads, err := typedFn(first, second)
+			return makeTypedHandlerResult(ctx, nil, payloads, err)
+		}
+	}
+	typedFn := *(*func(unsafe.Pointer, unsafe.Pointer) RouteHandlerResult)(unsafe.Pointer(&fnWord))
+	return func(ctx *Context, first, second unsafe.Pointer) RouteHandlerResult {
+		return typedFn(first, second)
+	}
+}
+
+// fnWord called w/ 3 pointer parameters
+func pointerCall3(fnWord unsafe.Pointer, results handlerResults) func(ctx *Context, first, second, third unsafe.Pointer) RouteHandlerResult {
+	switch results {
+	case returnsPayload:
+		typedFn := *(*func(unsafe.Pointer, unsafe.Pointer, unsafe.Pointer) (Payload, error))(unsafe.Pointer(&fnWord))
+		return func(ctx *Context, first, second, third unsafe.Pointer) RouteHandlerResult {
+			payload, err := typedFn(first, second, third)
+			return makeTypedHandlerResult(ctx, payload, nil, err)
+		}
+	case returnsPayloads:
+		typedFn := *(*func(unsafe.Pointer, unsafe.Pointer, unsafe.Pointer) ([]Payload, error))(unsafe.Pointer(&fnWord))
+		return func(ctx *Context, first, second, third unsafe.Pointer) RouteHandlerResult {
+			payloads, err := typedFn(first, second, third)
+			return makeTypedHandlerResult(ctx, nil, payloads, err)
+		}
+	}
+	typedFn := *(*func(unsafe.Pointer, unsafe.Pointer, unsafe.Pointer) RouteHandlerResult)(unsafe.Pointer(&fnWord))
+	return func(ctx *Context, first, second, third unsafe.Pointer) RouteHandlerResult {
+		return typedFn(first, second, third)
+	}
+}
+
+// Decodes each request's body into a new T, returned as a *T.
+// The body is decoded into a **T holding nil, which leaves allocating the T to the JSON decoder.
+func makeBodyDecoder(bodyPtrType reflect.Type) func(*Context) (unsafe.Pointer, error) {
+	bodyPtrPtrType := reflect.Zero(reflect.PtrTo(bodyPtrType)).Interface()
+	typeWord := (*emptyInterface)(unsafe.Pointer(&bodyPtrPtrType)).typ
+
+	return func(ctx *Context) (unsafe.Pointer, error) {
+		bodyPtr := new(unsafe.Pointer) // laid out just like a *T
+		var bodyReference interface{}
+		*(*emptyInterface)(unsafe.Pointer(&bodyReference)) = emptyInterface{typeWord, unsafe.Pointer(bodyPtr)}
+		if err := decodeTypedHandlerBody(ctx, bodyReference); err != nil {
+			return nil, err
+		}
+		if *bodyPtr == nil {
+			return nil, nullBodyError()
+		}
+		return *bodyPtr, nil
+	}
+}
+
+// Generic equivalents of the body binding handler signatures, for routes added via Handle:
+//
+//	routerPtr.Handle("POST", "1", "book", "", eprouter.PayloadBodyHandler(createBook), nil)
+//
+// where createBook is a func(*eprouter.Context, *BookPayload) (eprouter.Payload, error)
+func BodyHandler[T any](typedHandler func(*Context, *T) RouteHandlerResult) RouteHandler {
+	return func(ctx *Context) RouteHandlerResult {
+		body, err := decodeBody[T](ctx)
+		if err != nil {
+			return ctx.MakeRouteHandlerResultFromError(err)
+		}
+		return typedHandler(ctx, body)
+	}
+}
+
+func PayloadBodyHandler[T any](typedHandler func(*Context, *T) (Payload, error)) RouteHandler {
+	return func(ctx *Context) RouteHandlerResult {
+		body, err := decodeBody[T](ctx)
+		if err != nil {
+			return ctx.MakeRouteHandlerResultFromError(err)
+		}
+		payload, err := typedHandler(ctx, body)
+		return makeTypedHandlerResult(ctx, payload, nil, err)
+	}
+}
+
+func PayloadsBodyHandler[T any](typedHandler func(*Context, *T) ([]Payload, error)) RouteHandler {
+	return func(ctx *Context) RouteHandlerResult {
+		body, err := decodeBody[T](ctx)
+		if err != nil {
+			return ctx.MakeRouteHandlerResultFromError(err)
+		}
+		payloads, err := typedHandler(ctx, body)
+		return makeTypedHandlerResult(ctx, nil, payloads, err)
+	}
+}
+
+// Same as makeBodyDecoder, for the generic handlers
+func decodeBody[T any](ctx *Context) (*T, error) {
+	var body *T
+	if err := decodeTypedHandlerBody(ctx, &body); err != nil {
+		return nil, err
+	}
+	if body == nil {
+		return nil, nullBodyError()
+	}
+	return body, nil
+}
+
+// a body of null leaves nothing to bind
+func nullBodyError() *RouteError {
+	return NewRouteError(http.StatusBadRequest, ErrorInfo{ErrorNumber: 1702265431, ErrorMessage: BadRequestPrefix + ": Expected non-empty body"})
+}
+
+func decodeTypedHandlerBody(ctx *Context, bodyReference interface{}) error {
+	if ctx.router != nil && ctx.router.DecodeOptions.ApplyToTypedHandlers {
+		return ctx.DecodeRequestBody(bodyReference)
+	}
+
+	bodyBytes, err := ctx.RequestBody()
+	if rerr, isRouteError := err.(*RouteError); isRouteError {
+		// eg: 413
+		return rerr
+	} else if err != nil {
+		return NewRouteError(http.StatusBadRequest, ErrorInfo{ErrorNumber: 1702265430, ErrorMessage: BadRequestPrefix + ": Cannot read body"})
+	}
+	if len(bodyBytes) == 0 {
+		return NewRouteError(http.StatusBadRequest, ErrorInfo{ErrorNumber: 1702265431, ErrorMessage: BadRequestPrefix + ": Expected non-empty body"})
+	}
+	err = json.Unmarshal(bodyBytes, bodyReference)
+	if err != nil {
+		derr := deeperror.New(1702265432, BadRequestPrefix+": Cannot parse body", err)
+		log.Println("derr", derr)
+		return NewRouteError(http.StatusBadRequest, ErrorInfo{ErrorNumber: derr.Num, ErrorMessage: derr.EndUserMsg})
+	}
+	return nil
+}
+
+func makeTypedHandlerResult(ctx *Context, payload Payload, payloads []Payload, err error) RouteHandlerResult {
+	if err != nil {
+		return ctx.MakeRouteHandlerResultFromError(err)
+	}
+	if payload != nil {
+		payloads = []Payload{payload}
+	}
+	return ctx.MakeRouteHandlerResultPayloads(payloads...)
+}
@@ -0,0 +1,157 @@
+package eprouter
+
+import (
+	"log"
+	"sort"
+	"time"
+)
+
+// Optional.  If a PayloadController implements this, RegisterEntity sets the Timeout of the route for each handler.
+// handlerName is the method name, eg: "GetHandlerV1Popular".  returning 0 means no timeout.
+type RouteTimeoutProvider interface {
+	RouteTimeout(handlerName string) time.Duration
+}
+
+// Optional.  If a PayloadController implements this, RegisterEntity sets the MaxBodyBytes of the route for each handler.
+// returning 0 keeps Router.MaxBodyBytes, < 0 is unlimited.
+type RouteMaxBodyBytesProvider interface {
+	RouteMaxBodyBytes(handlerName string) int64
+}
+
+// Optional.  If a PayloadController implements this, RegisterEntity attaches the returned metadata to the route for each handler.
+// handlerName is the method name, eg: "GetHandlerV1Popular".  returning nil is fine.
+type RouteMetadataProvider interface {
+	RouteMetadata(handlerName string) map[string]string
+}
+
+const RouteInfoPayloadType = "route"
+
+// A structured, read-only description of a single registered route.
+// Also a Payload, so the list can be served directly (see Router.HandleRoutesEndpoint)
+type RouteInfo struct {
+	Method         string            `json:"method"`
+	Path           string            `json:"path"`              // full path including BasePath, eg: /api/v1/author/:author/book/popular
+	Version        string            `json:"version,omitempty"` // empty for unversioned routes
+	Entity         string            `json:"entity,omitempty"`  // entity path, eg: "author/book".  empty for entity-less routes
+	Action         string            `json:"action,omitempty"`
+	ControllerName string            `json:"controller,omitempty"`
+	HandlerName    string            `json:"handler,omitempty"`
+	RequiresAuth   bool              `json:"requiresAuth"`
+	PrimaryKey     string            `json:"primaryKey"`             // "optional", "required" or "forbidden"
+	Timeout        string            `json:"timeout,omitempty"`      // eg: "1.5s", empty for no timeout
+	MaxBodyBytes   int64             `json:"maxBodyBytes,omitempty"` // only if overridden, see Route.MaxBodyBytes
+	Metadata       map[string]string `json:"metadata,omitempty"`
+
+	// set if the route is deprecated, either directly or via its version or entity
+	Deprecated    bool   `json:"deprecated,omitempty"`
+	DeprecatedAt  string `json:"deprecatedAt,omitempty"`  // RFC 3339
+	Sunset        string `json:"sunset,omitempty"`        // RFC 3339
+	SuccessorLink string `json:"successorLink,omitempty"` // URL of the replacement
+}
+
+func (info RouteInfo) PayloadType() string {
+	return RouteInfoPayloadType
+}
+
+// Every registered route, sorted by method, version (numerically, unversioned first), entity then action.
+// The returned slice (and metadata maps) are copies, safe to modify.
+func (router *Router) Routes() []RouteInfo {
+	table := router.routes()
+	routeKeys := make([]string, 0, len(table.routeMap))
+	for routeKey := range table.routeMap {
+		routeKeys = append(routeKeys, routeKey)
+	}
+	sort.Slice(routeKeys, func(i, j int) bool {
+		a, b := table.routeMap[routeKeys[i]], table.routeMap[routeKeys[j]]
+		switch {
+		case a.Method != b.Method:
+			return a.Method < b.Method
+		case a.version() != b.version():
+			return a.version() < b.version()
+		case a.EntityPath() != b.EntityPath():
+			return a.EntityPath() < b.EntityPath()
+		case a.Action != b.Action:
+			return a.Action < b.Action
+		}
+		// item and collection routes of the same path
+		return routeKeys[i] < routeKeys[j]
+	})
+
+	infos := make([]RouteInfo, 0, len(routeKeys))
+	for _, routeKey := range routeKeys {
+		routePtr := table.routeMap[routeKey]
+		info := routePtr.info(router.BasePath)
+		info.setDeprecation(table.deprecationFor(routePtr, routePtr.version()))
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+func (route *Route) info(basePath string) RouteInfo {
+	info := RouteInfo{
+		Method:         route.Method,
+		Path:           route.fullPath(basePath),
+		Version:        route.VersionStr,
+		Entity:         route.EntityPath(),
+		Action:         route.Action,
+		ControllerName: route.ControllerName,
+		HandlerName:    route.HandlerName,
+		RequiresAuth:   route.RequiresAuth,
+		PrimaryKey:     route.KeyPolicy.String(),
+		MaxBodyBytes:   route.MaxBodyBytes,
+	}
+	if route.Timeout > 0 {
+		info.Timeout = route.Timeout.String()
+	}
+	if route.Metadata != nil {
+		info.Metadata = make(map[string]string, len(route.Metadata))
+		for key, value := range route.Metadata {
+			info.Metadata[key] = value
+		}
+	}
+	return info
+}
+
+func (info *RouteInfo) setDeprecation(deprecation *Deprecation) {
+	if deprecation == nil {
+		return
+	}
+	info.Deprecated = true
+	if deprecation.Date.IsZero() == false {
+		info.DeprecatedAt = deprecation.Date.UTC().Format(time.RFC3339)
+	}
+	if deprecation.Sunset.IsZero() == false {
+		info.Sunset = deprecation.Sunset.UTC().Format(time.RFC3339)
+	}
+	info.SuccessorLink = deprecation.Link
+}
+
+// Optional built-in endpoint which serves Routes() as a PayloadWrapper (payload type "route").
+// eg: router.HandleRoutesEndpoint("", "", "routes", nil) serves GET /api/routes
+// The list is built per request, so routes registered later are included.
+func (router *Router) HandleRoutesEndpoint(version, entity, action string, options *RouteOptions) {
+	if err := router.TryHandleRoutesEndpoint(version, entity, action, options); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// Like HandleRoutesEndpoint, but returns a *RegistrationError instead of calling log.Fatalln
+func (router *Router) TryHandleRoutesEndpoint(version, entity, action string, options *RouteOptions) error {
+	endpointOptions := RouteOptions{}
+	if options != nil {
+		endpointOptions = *options
+	}
+	if endpointOptions.HandlerName == "" {
+		endpointOptions.HandlerName = "RoutesEndpoint"
+	}
+	return router.TryHandle("GET", version, entity, action, router.routesHandler, &endpointOptions)
+}
+
+func (router *Router) routesHandler(ctx *Context) RouteHandlerResult {
+	infos := router.Routes()
+	payloads := make([]Payload, 0, len(infos))
+	for _, info := range infos {
+		payloads = append(payloads, info)
+	}
+	return ctx.MakeRouteHandlerResultPayloads(payloads...)
+}
@@ -0,0 +1,272 @@
+package eprouter
+
+import (
+	"log"
+	"strings"
+)
+
+// An immutable snapshot of everything ServeHTTP needs to dispatch a request.
+//
+// Registration changes the router's working set (see routeSet) under a lock, and a freshly built routeTable is then
+// published atomically.  Each request loads the current table once, so in-flight requests finish against the table
+// they started with, and registering while serving is safe.
+type routeTable struct {
+	routeMap      map[string]*Route // key is routeKey
+	controllers   map[string]PayloadController
+	deprecations  map[string]*Deprecation // key is deprecationScopeKey
+	conflicts     []RouteConflict
+	tree          *routeTree
+	hasRootRoutes bool // true if any entity-less routes are registered
+
+	// key is parent entity path (eg: "author" or "author/book"), value is the set of child entity names
+	childEntities map[string]map[string]bool
+}
+
+func newRouteTable() *routeTable {
+	table := new(routeTable)
+	table.routeMap = make(map[string]*Route)
+	table.controllers = make(map[string]PayloadController)
+	table.deprecations = make(map[string]*Deprecation)
+	table.tree = newRouteTree()
+	table.childEntities = make(map[string]map[string]bool)
+	return table
+}
+
+// Everything registered so far.  Only changed between lockRoutes and unlockRoutes, and the only thing tables are built from.
+// Router.RouteMap, Controllers and Conflicts are copies of it, kept in step for compatibility.
+type routeSet struct {
+	routeMap     map[string]*Route            // key is routeKey
+	controllers  map[string]PayloadController // key is entity path
+	deprecations map[string]*Deprecation      // key is deprecationScopeKey
+	conflicts    []RouteConflict
+}
+
+func newRouteSet() routeSet {
+	return routeSet{
+		routeMap:     make(map[string]*Route),
+		controllers:  make(map[string]PayloadController),
+		deprecations: make(map[string]*Deprecation),
+	}
+}
+
+func (set routeSet) clone() routeSet {
+	clone := newRouteSet()
+	for rk, routePtr := range set.routeMap {
+		clone.routeMap[rk] = routePtr
+	}
+	for entityPath, payloadController := range set.controllers {
+		clone.controllers[entityPath] = payloadController
+	}
+	for scopeKey, deprecation := range set.deprecations {
+		clone.deprecations[scopeKey] = deprecation
+	}
+	clone.conflicts = append(clone.conflicts, set.conflicts...)
+	return clone
+}
+
+// The working set is changed via these, which keep the exported copies in step.
+
+func (router *Router) putWorkingRoute(rk string, routePtr *Route) {
+	router.working.routeMap[rk] = routePtr
+	router.RouteMap[rk] = routePtr
+}
+func (router *Router) deleteWorkingRoute(rk string) {
+	delete(router.working.routeMap, rk)
+	delete(router.RouteMap, rk)
+}
+func (router *Router) putWorkingController(entityPath string, payloadController PayloadController) {
+	router.working.controllers[entityPath] = payloadController
+	router.Controllers[entityPath] = payloadController
+}
+func (router *Router) deleteWorkingController(entityPath string) {
+	delete(router.working.controllers, entityPath)
+	delete(router.Controllers, entityPath)
+}
+func (router *Router) addWorkingConflict(conflict RouteConflict) {
+	router.working.conflicts = append(router.working.conflicts, conflict)
+	router.Conflicts = append(router.Conflicts, conflict)
+}
+
+func (router *Router) removeWorkingConflicts(isRemoved func(RouteConflict) bool) {
+	kept := []RouteConflict{}
+	for _, conflict := range router.working.conflicts {
+		if isRemoved(conflict) == false {
+			kept = append(kept, conflict)
+		}
+	}
+	router.working.conflicts = kept
+	router.Conflicts = append([]RouteConflict(nil), kept...)
+}
+
+// replaces the working set, and the exported copies w/ fresh ones
+func (router *Router) setWorkingSet(set routeSet) {
+	router.working = set
+	router.RouteMap = make(map[string]*Route, len(set.routeMap))
+	for rk, routePtr := range set.routeMap {
+		router.RouteMap[rk] = routePtr
+	}
+	router.Controllers = make(map[string]PayloadController, len(set.controllers))
+	for entityPath, payloadController := range set.controllers {
+		router.Controllers[entityPath] = payloadController
+	}
+	router.Conflicts = append([]RouteConflict(nil), set.conflicts...)
+}
+
+// The currently published table.  Never nil.
+// Changes made before the router started serving are published here, once, instead of after every registration.
+func (router *Router) routes() *routeTable {
+	if router.isStale.Load() {
+		router.updateMutex.Lock()
+		if router.isStale.Load() {
+			router.publishRoutes()
+		}
+		router.updateMutex.Unlock()
+	}
+	table, _ := router.table.Load().(*routeTable)
+	if table == nil {
+		return newRouteTable()
+	}
+	return table
+}
+
+// Routes are never modified after they are published, so they are shared between the working set and the tables.
+// Only called w/ updateMutex held (or from NewRouter)
+func (router *Router) publishRoutes() {
+	table := newRouteTable()
+	for rk, routePtr := range router.working.routeMap {
+		table.routeMap[rk] = routePtr
+		if err := table.tree.insert(routePtr); err != nil {
+			// versions are validated before we get here, so this really shouldn't happen
+			log.Fatalln("2156304861 Invalid version:", routePtr.VersionStr, "entity:", routePtr.EntityPath(), "method:", routePtr.Method, "action:", routePtr.Action, err)
+		}
+		if routePtr.EntityName == "" {
+			table.hasRootRoutes = true
+		}
+		table.indexChildEntity(routePtr)
+	}
+	for entityPath, payloadController := range router.working.controllers {
+		table.controllers[entityPath] = payloadController
+	}
+	for scopeKey, deprecation := range router.working.deprecations {
+		table.deprecations[scopeKey] = deprecation
+	}
+	table.conflicts = append(table.conflicts, router.working.conflicts...)
+
+	router.table.Store(table)
+	router.isStale.Store(false)
+}
+
+// Every change to the working set happens between lockRoutes and unlockRoutes.
+// Once the router is serving, unlockRoutes publishes the result right away.  Before that (eg: registering at startup)
+// it is published lazily, so registering n entities doesn't build n tables.  Staging routers (see Update) never publish.
+func (router *Router) lockRoutes() {
+	router.updateMutex.Lock()
+}
+func (router *Router) unlockRoutes() {
+	if router.isStaging == false {
+		router.isStale.Store(true)
+		if router.isServing.Load() {
+			// so that requests never wait for the lock
+			router.publishRoutes()
+		}
+	}
+	router.updateMutex.Unlock()
+}
+
+// record the nesting so that parsed endpoints can be resolved to their child entity
+func (table *routeTable) indexChildEntity(route *Route) {
+	entityNames := append(route.ParentEntityNames[:len(route.ParentEntityNames):len(route.ParentEntityNames)], route.EntityName)
+	for i := 1; i < len(entityNames); i++ {
+		table.addChildEntity(joinEntityPath(entityNames[:i], ""), entityNames[i])
+	}
+}
+func (table *routeTable) addChildEntity(parentPath, childName string) {
+	parentPath = strings.ToLower(parentPath)
+	children, exists := table.childEntities[parentPath]
+	if exists == false {
+		children = make(map[string]bool)
+		table.childEntities[parentPath] = children
+	}
+	children[strings.ToLower(childName)] = true
+}
+
+func (table *routeTable) lookupRoute(method string, endpoint *Endpoint, fallback bool) *Route {
+	routePtr := table.tree.lookup(method, endpoint.Version(), endpoint)
+	if routePtr == nil && fallback {
+		routePtr = table.tree.lookupFallback(method, endpoint.Version(), endpoint)
+	}
+	return routePtr
+}
+
+// Makes several changes at once, then swaps them in atomically.  Requests never see a partially applied update.
+// change is given a staging router with a copy of the current routes.  Register or remove anything on the staging router
+// (NOT the original, that would deadlock).  If change returns an error, nothing is applied.
+//
+//	err := router.Update(func(staging *eprouter.Router) error {
+//		staging.RemoveEntity("promo")
+//		return staging.TryRegisterEntity("promo", &SummerPromoController{})
+//	})
+//
+// Updates are serialized with each other and with all other registration methods.
+func (router *Router) Update(change func(staging *Router) error) error {
+	router.lockRoutes()
+	defer router.unlockRoutes()
+
+	staging := router.newStagingRouter()
+	if err := change(staging); err != nil {
+		return err
+	}
+
+	router.setWorkingSet(staging.working)
+	return nil
+}
+
+// Same registration settings, a copy of the working routes, and never publishes.
+func (router *Router) newStagingRouter() *Router {
+	staging := NewRouter()
+	staging.isStaging = true
+	staging.BasePath = router.BasePath
+	staging.StrictRegistration = router.StrictRegistration
+	staging.ConflictPolicy = router.ConflictPolicy
+	staging.VersionFallback = router.VersionFallback
+	staging.setWorkingSet(router.working.clone())
+	return staging
+}
+
+// Unregisters an entity (eg: "book" or "author/book") along with any child entities nested underneath it,
+// and their deprecations and recorded conflicts.
+// Safe to call while serving, in-flight requests finish normally.
+// returns the number of routes removed
+func (router *Router) RemoveEntity(entityPath string) int {
+	router.lockRoutes()
+	defer router.unlockRoutes()
+
+	entityPath = strings.ToLower(strings.Trim(entityPath, "/"))
+	isRemoved := func(candidatePath string) bool {
+		candidatePath = strings.ToLower(candidatePath)
+		return candidatePath == entityPath || strings.HasPrefix(candidatePath, entityPath+"/")
+	}
+
+	removedCount := 0
+	for rk, routePtr := range router.working.routeMap {
+		if routePtr.EntityName != "" && isRemoved(routePtr.EntityPath()) {
+			router.deleteWorkingRoute(rk)
+			removedCount++
+		}
+	}
+	for controllerPath := range router.working.controllers {
+		if isRemoved(controllerPath) {
+			router.deleteWorkingController(controllerPath)
+		}
+	}
+	// so that they don't apply to an entity registered under the same name later
+	for scopeKey := range router.working.deprecations {
+		if scopeEntityPath := deprecationScopeEntityPath(scopeKey); scopeEntityPath != "" && isRemoved(scopeEntityPath) {
+			delete(router.working.deprecations, scopeKey)
+		}
+	}
+	router.removeWorkingConflicts(func(conflict RouteConflict) bool {
+		return conflict.Existing.EntityName != "" && isRemoved(conflict.Existing.EntityPath())
+	})
+	return removedCount
+}
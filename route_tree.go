@@ -0,0 +1,204 @@
+package eprouter
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The dispatch tree is precompiled at registration:
+//
+//	method → version → entity (→ child entity...) → action → *Route
+//
+// Lookups are just a handful of map reads, no string joining or allocation per request.
+// Entity and action keys are stored lowercased.
+// Unversioned routes are stored under version 0.
+// Entity-less routes (eg: /v1/status) are stored directly in the actions of the version root.
+
+type routeTree struct {
+	methods map[string]*methodNode // key is uppercased http method
+}
+
+type methodNode struct {
+	versions       map[VersionUint]*entityNode // the root entityNode of each version has no actions
+	sortedVersions []VersionUint               // ascending, only used for VersionFallback
+}
+
+type entityNode struct {
+	actions     map[string]*Route      // key is lowercased action, "" for no action
+	collections map[string]*Route      // PrimaryKeyForbidden routes, same keys as actions
+	children    map[string]*entityNode // key is lowercased entity name
+}
+
+func newRouteTree() *routeTree {
+	tree := new(routeTree)
+	tree.methods = make(map[string]*methodNode)
+	return tree
+}
+
+func newEntityNode() *entityNode {
+	node := new(entityNode)
+	node.actions = make(map[string]*Route)
+	node.collections = make(map[string]*Route)
+	node.children = make(map[string]*entityNode)
+	return node
+}
+
+func (tree *routeTree) insert(route *Route) error {
+	var version VersionUint
+	if route.VersionStr != "" {
+		v64, err := strconv.ParseUint(route.VersionStr, 10, VERSION_BIT_DEPTH)
+		if err != nil {
+			return err
+		}
+		version = VersionUint(v64)
+	}
+
+	mNode, exists := tree.methods[route.Method]
+	if exists == false {
+		mNode = new(methodNode)
+		mNode.versions = make(map[VersionUint]*entityNode)
+		tree.methods[route.Method] = mNode
+	}
+
+	node, exists := mNode.versions[version]
+	if exists == false {
+		node = newEntityNode()
+		mNode.versions[version] = node
+		mNode.insertSortedVersion(version)
+	}
+
+	for _, entityName := range route.ParentEntityNames {
+		node = node.child(entityName)
+	}
+	if route.EntityName != "" {
+		node = node.child(route.EntityName)
+	}
+	if route.KeyPolicy == PrimaryKeyForbidden {
+		node.collections[strings.ToLower(route.Action)] = route
+	} else {
+		node.actions[strings.ToLower(route.Action)] = route
+	}
+	return nil
+}
+
+func (mNode *methodNode) insertSortedVersion(version VersionUint) {
+	versions := mNode.sortedVersions
+	i := sort.Search(len(versions), func(i int) bool { return versions[i] >= version })
+	versions = append(versions, 0)
+	copy(versions[i+1:], versions[i:])
+	versions[i] = version
+	mNode.sortedVersions = versions
+}
+
+func (node *entityNode) child(entityName string) *entityNode {
+	key := strings.ToLower(entityName)
+	childNode, exists := node.children[key]
+	if exists == false {
+		childNode = newEntityNode()
+		node.children[key] = childNode
+	}
+	return childNode
+}
+
+// exact match only
+func (tree *routeTree) lookup(method string, version VersionUint, endpoint *Endpoint) *Route {
+	mNode := tree.methods[method]
+	if mNode == nil {
+		return nil
+	}
+	return mNode.versions[version].lookup(endpoint)
+}
+
+// returns the route w/ the highest registered version <= requestedVersion
+func (tree *routeTree) lookupFallback(method string, requestedVersion VersionUint, endpoint *Endpoint) *Route {
+	if requestedVersion == 0 {
+		// 0 is never a valid version, usually means the version failed to parse
+		return nil
+	}
+	mNode := tree.methods[method]
+	if mNode == nil {
+		return nil
+	}
+	// versions are sorted ascending, so walk backwards
+	for i := len(mNode.sortedVersions) - 1; i >= 0; i-- {
+		version := mNode.sortedVersions[i]
+		if version > requestedVersion {
+			continue
+		}
+		if version == 0 {
+			// never fall back to unversioned routes
+			break
+		}
+		if route := mNode.versions[version].lookup(endpoint); route != nil {
+			return route
+		}
+	}
+	return nil
+}
+
+// walks down from a version root through any parents to the entity, then picks the action
+func (node *entityNode) lookup(endpoint *Endpoint) *Route {
+	if node == nil {
+		return nil
+	}
+	for _, parent := range endpoint.Parents {
+		node = node.children[strings.ToLower(parent.EntityName)]
+		if node == nil {
+			return nil
+		}
+	}
+	if endpoint.EntityName != "" {
+		node = node.children[strings.ToLower(endpoint.EntityName)]
+		if node == nil {
+			return nil
+		}
+	}
+	// collection routes are preferred w/o a primary key, item routes w/ one.
+	// If only one of them exists, it's returned regardless, and the router enforces its KeyPolicy.
+	action := strings.ToLower(endpoint.Action)
+	first, second := node.actions, node.collections
+	if endpoint.PrimaryKey == "" {
+		first, second = second, first
+	}
+	if route := first[action]; route != nil {
+		return route
+	}
+	return second[action]
+}
+
+// All the methods which have a route for this endpoint, sorted.
+// Used for 405 Method Not Allowed and OPTIONS responses, not on the happy path.
+func (tree *routeTree) allowedMethods(version VersionUint, endpoint *Endpoint, fallback bool) []string {
+	methods := []string{}
+	hasMethod := func(method string) bool {
+		if tree.lookup(method, version, endpoint) != nil {
+			return true
+		}
+		return fallback && tree.lookupFallback(method, version, endpoint) != nil
+	}
+	for method := range tree.methods {
+		if hasMethod(method) {
+			methods = append(methods, method)
+			if method == "GET" && hasMethod("HEAD") == false {
+				// HEAD is derived from GET, unless this endpoint has its own
+				methods = append(methods, "HEAD")
+			}
+		}
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// true if any method has a route for this endpoint
+func (tree *routeTree) hasAnyRoute(version VersionUint, endpoint *Endpoint, fallback bool) bool {
+	for method := range tree.methods {
+		if tree.lookup(method, version, endpoint) != nil {
+			return true
+		}
+		if fallback && tree.lookupFallback(method, version, endpoint) != nil {
+			return true
+		}
+	}
+	return false
+}
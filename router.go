@@ -5,9 +5,14 @@ import (
 	"log"
 	"net/http"
 	"reflect"
+	"runtime"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 
+	"github.com/amattn/deeperror"
 	"github.com/amattn/deeperror/levels"
 )
 
@@ -20,6 +25,12 @@ const (
 	BadRequestSyntaxErrorErrorNumber          = 4000000001
 	BadRequestMissingPrimaryKeyErrorNumber    = 4000000002
 	BadRequestExtraneousPrimaryKeyErrorNumber = 4000000003
+	BadRequestMissingPrimaryKeyPrefix         = BadRequestPrefix + ": Missing Primary Key"
+	BadRequestExtraneousPrimaryKeyPrefix      = BadRequestPrefix + ": Extraneous Primary Key"
+	BadRequestMalformedPrimaryKeyErrorNumber  = 4000000004
+	BadRequestMalformedPrimaryKeyPrefix       = BadRequestPrefix + ": Malformed Primary Key"
+	MethodNotAllowedPrefix                    = "405 Method Not Allowed"
+	MethodNotAllowedErrorNumber               = 4050000405
 
 	InternalServerErrorPrefix = "500 Internal Server Error"
 )
@@ -30,26 +41,65 @@ type PayloadController interface {
 type Router struct {
 	BasePath string
 
+	// When true, handler-like methods which can't be routed (eg: FetchHandlerV1, GetHandlerv1, or the wrong signature)
+	// are registration errors instead of just being logged and skipped.
+	StrictRegistration bool
+
+	// What to do when two handlers resolve to the same method, version, entity and action.
+	// Defaults to ConflictPolicyWarn.
+	ConflictPolicy ConflictPolicy
+
+	// Every conflict detected during registration (except for ConflictPolicyError, which refuses to register them)
+	// Read-only, like RouteMap
+	Conflicts []RouteConflict
+
+	// Enables media type versioning for paths without a /v<n>/ segment.  eg: "collectivehealth" accepts
+	// Accept: application/vnd.collectivehealth.book.v2+json as version 2, and echoes it as the response Content-Type.
+	// Path versions always take precedence.  Off (empty) by default.
+	VendorMediaTypeName string
+
+	// When true, a request for a version with no exactly matching handler is served by the
+	// highest registered version below it.  eg: /v3/book with only GetHandlerV1 and GetHandlerV2 is served by GetHandlerV2
+	// Exact matches always win.  Off by default.
+	VersionFallback bool
+
+	// Used by ctx.DecodeRequestBody and typed handlers.  see DecodeOptions
+	DecodeOptions DecodeOptions
+
+	// Requests w/ larger bodies are answered w/ a 413.  Routes may override it via Route.MaxBodyBytes.
+	// 0 (the default) is unlimited.
+	MaxBodyBytes int64
+
 	PreProcessors        []PreProcessor
 	MiddlewareProcessors []MiddlewareProcessor
 	PostProcessors       []PostProcessor
 
-	Controllers map[string]PayloadController // key is entity name
-	RouteMap    map[string]*Route            // key is entity name
+	// Controllers, RouteMap (and Conflicts) are read-only copies of what has been registered, kept for compatibility.
+	// The router itself never reads them (see routeSet), so modifying these maps has no effect on routing,
+	// and reading them while registering from another goroutine is a data race.  Use Routes() instead.
+	Controllers map[string]PayloadController // key is entity path
+	RouteMap    map[string]*Route            // key is routeKey
+
+	working     routeSet     // what has been registered, the source of every published table
+	updateMutex sync.Mutex   // serializes all changes to the working set
+	table       atomic.Value // *routeTable, the published snapshot
+	isStale     atomic.Bool  // true if the working set has changes which haven't been published yet
+	isServing   atomic.Bool  // set by the first request, from then on changes are published right away
+	isStaging   bool         // true for the staging router given to Update's change func
 }
 
 func NewRouter() *Router {
 
 	router := new(Router)
 
-	router.Controllers = make(map[string]PayloadController)
-	router.RouteMap = make(map[string]*Route)
+	router.setWorkingSet(newRouteSet())
 
 	router.PreProcessors = []PreProcessor{}
 	router.MiddlewareProcessors = []MiddlewareProcessor{}
 	router.PostProcessors = []PostProcessor{
 		new(CommonLogger),
 	}
+	router.publishRoutes()
 	return router
 }
 
@@ -64,123 +114,374 @@ func NewRouter() *Router {
 
 // Configuration of Router
 
+// Registration methods come in two flavors:
+// - RegisterEntity, RegisterChildEntity, AddEntityRoute, Handle: call log.Fatalln on any registration problem.
+// - TryRegisterEntity, TryRegisterChildEntity, TryAddEntityRoute, TryHandle: return a *RegistrationError instead.
+// A controller with any problems has none of its routes registered.
+// All of them are safe to call while serving, see Update for making several changes at once.
+
 func (router *Router) RegisterEntity(name string, payloadController PayloadController) {
-	payloadControllerType := reflect.TypeOf(payloadController)
-	payloadControllerValue := reflect.ValueOf(payloadController)
+	if err := router.TryRegisterEntity(name, payloadController); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// Like RegisterEntity, but returns every problem found in one *RegistrationError instead of calling log.Fatalln
+func (router *Router) TryRegisterEntity(name string, payloadController PayloadController) error {
+	return router.registerEntity(nil, name, payloadController)
+}
+
+// Registers an entity nested underneath a parent entity.
+// eg: RegisterChildEntity("author", "book", &BookController{}) routes /v1/author/7/book/3 to the BookController
+// with the author key available in ctx.Endpoint.Parents
+// parentPath may itself be nested: "author/book" for /v1/author/7/book/3/page/2
+// The child is only routable underneath its parent.  To also route /v1/book/3, register it with RegisterEntity as well.
+func (router *Router) RegisterChildEntity(parentPath string, name string, payloadController PayloadController) {
+	if err := router.TryRegisterChildEntity(parentPath, name, payloadController); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// Like RegisterChildEntity, but returns every problem found in one *RegistrationError instead of calling log.Fatalln
+func (router *Router) TryRegisterChildEntity(parentPath string, name string, payloadController PayloadController) error {
+	parentNames := strings.Split(strings.Trim(parentPath, "/"), "/")
+	for _, parentName := range parentNames {
+		if isValid, reason := ValidateEntityName(parentName); isValid == false {
+			regErr := newRegistrationError(joinEntityPath(parentNames, name), controllerNameOf(payloadController))
+			regErr.add(deeperror.New(1908126834, fmt.Sprint("Invalid parent Enitity name:'", parentName, "' in '", parentPath, "' ", reason), nil))
+			return regErr
+		}
+	}
+	return router.registerEntity(parentNames, name, payloadController)
+}
+
+func (router *Router) registerEntity(parentNames []string, name string, payloadController PayloadController) error {
+	entityPath := joinEntityPath(parentNames, name)
+	regErr := newRegistrationError(entityPath, controllerNameOf(payloadController))
 
 	if isValid, reason := ValidateEntityName(name); isValid == false {
-		log.Fatalln("Invalid Enitity name:'", name, "'", reason)
+		regErr.add(deeperror.New(1908126835, fmt.Sprint("Invalid Enitity name:'", name, "' ", reason), nil))
+	}
+	if strings.Contains(name, "/") {
+		regErr.add(deeperror.New(1908126836, fmt.Sprint("Invalid Enitity name:'", name, "' use RegisterChildEntity for nested entities"), nil))
 	}
 	if payloadController == nil {
-		log.Fatalln("untypedHandlerWrapper currently must not be nil")
+		regErr.add(deeperror.New(1908126837, "untypedHandlerWrapper currently must not be nil", nil))
+		return regErr
 	}
 
-	router.Controllers[name] = payloadController
-
+	payloadControllerType := reflect.TypeOf(payloadController)
+	receiver, receiverType := controllerReceiver(payloadController)
 	authenticator, _ := payloadController.(AuthHandler)
+	metadataProvider, _ := payloadController.(RouteMetadataProvider)
+	keyParser, _ := payloadController.(PrimaryKeyParser)
+	timeoutProvider, _ := payloadController.(RouteTimeoutProvider)
+	maxBodyBytesProvider, _ := payloadController.(RouteMaxBodyBytesProvider)
 
+	routes := []*Route{}
 	for i := 0; i < payloadControllerType.NumMethod(); i++ {
 
 		potentialHandlerMethod := payloadControllerType.Method(i)
 		potentialHandlerName := potentialHandlerMethod.Name
 		if len(potentialHandlerName) > 0 && potentialHandlerName[0] == strings.ToUpper(potentialHandlerName)[0] {
 			// skip unexported methods
-			unknownhandler := payloadControllerValue.MethodByName(potentialHandlerName).Interface()
-			router.AddEntityRoute(name, payloadControllerType.String(), potentialHandlerName, unknownhandler, authenticator)
+			unknownhandler := newControllerMethod(receiver, receiverType, potentialHandlerName)
+			routePtr, skipped, derr := buildEntityRoute(entityPath, payloadControllerType.String(), potentialHandlerName, unknownhandler, authenticator)
+			router.collectRouteProblems(regErr, skipped, derr)
+			if routePtr != nil {
+				if metadataProvider != nil {
+					routePtr.Metadata = metadataProvider.RouteMetadata(potentialHandlerName)
+				}
+				if keyParser != nil {
+					routePtr.KeyParser = keyParser.ParsePrimaryKey
+				}
+				if timeoutProvider != nil {
+					routePtr.Timeout = timeoutProvider.RouteTimeout(potentialHandlerName)
+				}
+				if maxBodyBytesProvider != nil {
+					routePtr.MaxBodyBytes = maxBodyBytesProvider.RouteMaxBodyBytes(potentialHandlerName)
+				}
+				routes = append(routes, routePtr)
+			}
 		}
 	}
+	requirePrimaryKeysAlongsideCollections(routes)
+
+	router.lockRoutes()
+	defer router.unlockRoutes()
+	if router.commitRoutes(regErr, routes) == false {
+		return regErr
+	}
+	router.putWorkingController(entityPath, payloadController)
+	return nil
 }
 
+// entityName may be a nested entity path, eg: "author/book"
 func (router *Router) AddEntityRoute(entityName, controllerName, handlerName string, unknownhandler interface{}, authenticator AuthHandler) {
+	if err := router.TryAddEntityRoute(entityName, controllerName, handlerName, unknownhandler, authenticator); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// Like AddEntityRoute, but returns a *RegistrationError instead of calling log.Fatalln
+func (router *Router) TryAddEntityRoute(entityName, controllerName, handlerName string, unknownhandler interface{}, authenticator AuthHandler) error {
+	regErr := newRegistrationError(entityName, controllerName)
+	routePtr, skipped, derr := buildEntityRoute(entityName, controllerName, handlerName, unknownhandler, authenticator)
+	router.collectRouteProblems(regErr, skipped, derr)
+	routes := []*Route{}
+	if routePtr != nil {
+		routes = append(routes, routePtr)
+	}
+	router.lockRoutes()
+	defer router.unlockRoutes()
+	if router.commitRoutes(regErr, routes) == false {
+		return regErr
+	}
+	return nil
+}
+
+// The final step of all registration: check for conflicts, then add the routes if there are no problems.
+// returns false (and adds nothing) if regErr has any problems
+func (router *Router) commitRoutes(regErr *RegistrationError, routes []*Route) bool {
+	conflicts := router.findConflicts(routes)
+	if router.ConflictPolicy == ConflictPolicyError {
+		for _, conflict := range conflicts {
+			regErr.add(deeperror.New(2981640027, conflict.String(), nil))
+		}
+	}
+	if regErr.hasProblems() {
+		return false
+	}
+
+	for _, conflict := range conflicts {
+		if router.ConflictPolicy == ConflictPolicyWarn {
+			log.Println("2981640028 WARNING Route Conflict, last registered wins:", conflict.String())
+		}
+		router.addWorkingConflict(conflict)
+	}
+	for _, routePtr := range routes {
+		router.addRoute(routePtr)
+	}
+	return true
+}
+
+// Conflicts with already registered routes, and within routes itself (eg: GetHandlerV1 and GetHandlerV01)
+func (router *Router) findConflicts(routes []*Route) []RouteConflict {
+	conflicts := []RouteConflict{}
+	pending := make(map[string]*Route)
+	for _, routePtr := range routes {
+		rk := routeKeyOf(routePtr)
+		if existing, exists := pending[rk]; exists {
+			conflicts = append(conflicts, RouteConflict{Existing: existing, Replacement: routePtr})
+		} else if existing, exists := router.working.routeMap[rk]; exists {
+			conflicts = append(conflicts, RouteConflict{Existing: existing, Replacement: routePtr})
+		}
+		pending[rk] = routePtr
+	}
+	return conflicts
+}
+
+// The ListHandler convention: once a controller defines ListHandlerV<n><Action>, its Get, Put, Patch and Delete
+// handlers for the same version and action are item handlers, and require a primary key.
+// Its PostHandlerV<n> (create) is a collection handler, and takes none.  Controllers w/o a ListHandler are left alone.
+func requirePrimaryKeysAlongsideCollections(routes []*Route) {
+	collections := make(map[string]bool)
+	for _, routePtr := range routes {
+		if routePtr.Method == "GET" && routePtr.KeyPolicy == PrimaryKeyForbidden {
+			collections[routePtr.VersionStr+ROUTE_MAP_SEPARATOR+routePtr.Action] = true
+		}
+	}
+	for _, routePtr := range routes {
+		switch routePtr.Method {
+		case "GET", "PUT", "PATCH", "DELETE":
+			if routePtr.KeyPolicy == PrimaryKeyOptional && collections[routePtr.VersionStr+ROUTE_MAP_SEPARATOR+routePtr.Action] {
+				routePtr.KeyPolicy = PrimaryKeyRequired
+			}
+		case "POST":
+			if routePtr.KeyPolicy == PrimaryKeyOptional && routePtr.Action == "" && collections[routePtr.VersionStr+ROUTE_MAP_SEPARATOR] {
+				routePtr.KeyPolicy = PrimaryKeyForbidden
+			}
+		}
+	}
+}
+
+// Skipped routes are only logged, unless StrictRegistration is set.
+func (router *Router) collectRouteProblems(regErr *RegistrationError, skipped, derr *deeperror.DeepError) {
+	if derr != nil {
+		regErr.add(derr)
+	}
+	if skipped != nil {
+		if router.StrictRegistration {
+			regErr.add(skipped)
+		} else {
+			log.Println(skipped.Num, skipped.EndUserMsg)
+		}
+	}
+}
+
+// Builds (but doesn't add) a route from a magic handler name.
+// returns:
+// - nil, nil, nil if handlerName isn't a handler at all
+// - nil, skipped, nil if handlerName looks like a handler, but can't be routed
+// - nil, nil, derr for hard failures
+func buildEntityRoute(entityName, controllerName, handlerName string, unknownhandler interface{}, authenticator AuthHandler) (routePtr *Route, skipped, derr *deeperror.DeepError) {
 
 	// simple first:
 	if strings.Contains(handlerName, MAGIC_HANDLER_KEYWORD) == false {
 		// just skip it
-		return
+		return nil, nil, nil
 	}
 
 	isValid, reason, handler := ValidateHandler(unknownhandler)
 	if isValid == false {
-		errNum := int64(3230075622)
-		errMsg := fmt.Sprintln(errNum, "Handler Validation Failure:", "entityName:", entityName, "controllerName:", controllerName, "Invalid Handler:", handlerName, "reason:", reason)
-		// derr := deeperror.New(errNum, errMsg, nil)
-		log.Println(errMsg)
-		// skip... invalid prefix
-		return
+		errMsg := fmt.Sprint("Handler Validation Failure: entityName: ", entityName, " controllerName: ", controllerName, " Invalid Handler: ", handlerName, " reason: ", reason)
+		return nil, deeperror.New(3230075622, errMsg, nil), nil
 	}
 
-	routePtr := new(Route)
-	routePtr.Path = entityName + "/"
-	routePtr.EntityName = entityName
+	requiresAuth, method, versionStr, action, keyPolicy, skipped := parseHandlerName(handlerName)
+	if skipped != nil {
+		skipped.EndUserMsg = fmt.Sprint(skipped.EndUserMsg, " entityName: ", entityName, " controllerName: ", controllerName)
+		return nil, skipped, nil
+	}
+
+	routePtr = newEntityRoute(entityName)
 	routePtr.Handler = handler
 	routePtr.HandlerName = handlerName
 	routePtr.ControllerName = controllerName
+	routePtr.Method = method
+	routePtr.VersionStr = versionStr
+	routePtr.Action = action
+	routePtr.KeyPolicy = keyPolicy
 
-	// Step 1 Check for Auth prrefix
-	deauthedHandlerName := handlerName
-	if strings.HasPrefix(handlerName, MAGIC_AUTH_REQUIRED_PREFIX) {
-		deauthedHandlerName = handlerName[len(MAGIC_AUTH_REQUIRED_PREFIX):]
+	if requiresAuth {
 		routePtr.RequiresAuth = true
 		if authenticator == nil {
-			log.Fatalf("1323798307 Auth required handler defined (%s), but controller (%s) does not implement AuthHandler", handlerName, controllerName)
-			return
+			errMsg := fmt.Sprintf("Auth required handler defined (%s), but controller (%s) does not implement AuthHandler", handlerName, controllerName)
+			return nil, nil, deeperror.New(1323798307, errMsg, nil)
 		}
 		routePtr.Authenticator = authenticator
 	}
 
-	// step 2 Find method
-	var versionActionHandlerName string
-	switch {
-	case strings.HasPrefix(deauthedHandlerName, MAGIC_GET_HANDLER_PREFIX):
-		routePtr.Method = "GET"
-		versionActionHandlerName = deauthedHandlerName[len(MAGIC_GET_HANDLER_PREFIX):]
-	case strings.HasPrefix(deauthedHandlerName, MAGIC_POST_HANDLER_PREFIX):
-		routePtr.Method = "POST"
-		versionActionHandlerName = deauthedHandlerName[len(MAGIC_POST_HANDLER_PREFIX):]
-	case strings.HasPrefix(deauthedHandlerName, MAGIC_PUT_HANDLER_PREFIX):
-		routePtr.Method = "PUT"
-		versionActionHandlerName = deauthedHandlerName[len(MAGIC_PUT_HANDLER_PREFIX):]
-	case strings.HasPrefix(deauthedHandlerName, MAGIC_DELETE_HANDLER_PREFIX):
-		routePtr.Method = "DELETE"
-		versionActionHandlerName = deauthedHandlerName[len(MAGIC_DELETE_HANDLER_PREFIX):]
-	case strings.HasPrefix(deauthedHandlerName, MAGIC_PATCH_HANDLER_PREFIX):
-		routePtr.Method = "PATCH"
-		versionActionHandlerName = deauthedHandlerName[len(MAGIC_PATCH_HANDLER_PREFIX):]
-	case strings.HasPrefix(deauthedHandlerName, MAGIC_HEAD_HANDLER_PREFIX):
-		routePtr.Method = "HEAD"
-		versionActionHandlerName = deauthedHandlerName[len(MAGIC_HEAD_HANDLER_PREFIX):]
-	default:
-		// skip... it's not a known prefix
-		log.Println("1860816435 Skipping Route:", entityName, controllerName, handlerName)
-		return
+	if isValid, reason := ValidateHandlerName(handler); isValid == false {
+		errMsg := fmt.Sprint("entity name: ", routePtr.EntityName, " method: ", routePtr.Method, " route: ", routePtr.EntityPath(), " Invalid Handler: ", handlerName, " reason: ", reason)
+		return nil, nil, deeperror.New(1411397818, errMsg, nil)
 	}
 
-	// do a bit of primite parsing:
+	return routePtr, nil, nil
+}
 
-	if isValid, reason := ValidateHandlerName(handler); isValid == false {
-		log.Fatalln("1411397818 entity name:", routePtr.EntityName, "method:", routePtr.Method, "route:", routePtr.Path, "Invalid Handler:", handlerName, "reason:", reason)
+// Explicitly register a single route, no reflection or handler name magic required.
+// Useful for closures, generated code or handlers that don't follow the <Method>HandlerV<version><Action> naming.
+// The resulting route behaves identically to one registered via RegisterEntity.
+//
+// eg: routerPtr.Handle("GET", "1", "book", "popular", popularBooksHandler, nil)
+// serves GET http://host/<prefix>/v1/book/<optionalID>/popular
+//
+// method is upper cased, and must be a valid HTTP method token (eg: GET, PROPFIND).
+// version may be given as "1" or "v1". entity may be a nested entity path, eg: "author/book"
+// options may be nil.
+//
+// Entity-less and unversioned routes are also supported:
+//
+//	routerPtr.Handle("GET", "1", "", "status", statusHandler, nil) // GET http://host/<prefix>/v1/status
+//	routerPtr.Handle("GET", "", "", "healthz", healthHandler, nil) // GET http://host/<prefix>/healthz
+//
+// If an entity and an entity-less action share a name, the entity wins.
+func (router *Router) Handle(method, version, entity, action string, handler RouteHandler, options *RouteOptions) {
+	if err := router.TryHandle(method, version, entity, action, handler, options); err != nil {
+		log.Fatalln(err)
 	}
+}
 
-	// log.Println("versionActionHandlerName", versionActionHandlerName)
+// Like Handle, but returns a *RegistrationError instead of calling log.Fatalln
+func (router *Router) TryHandle(method, version, entity, action string, handler RouteHandler, options *RouteOptions) error {
+	if options == nil {
+		options = new(RouteOptions)
+	}
 
-	versionStr, action := parseVersionFromPrefixlessHandlerName(versionActionHandlerName)
-	if versionStr == "" {
-		log.Println("1259486570 Skipping Route:", entityName, controllerName, handlerName)
-		// skip... invalid prefix
-		return
+	entity = strings.Trim(entity, "/")
+	regErr := newRegistrationError(entity, options.ControllerName)
+
+	method = strings.ToUpper(method)
+	if isValid, reason := ValidateMethod(method); isValid == false {
+		regErr.add(deeperror.New(2714862379, fmt.Sprint("Invalid method:'", method, "' entity: ", entity, " ", reason), nil))
 	}
 
-	// log.Println("version, action", version, action)
-	routePtr.Action = action
-	routePtr.Path += action
+	if entity != "" {
+		for _, entityName := range strings.Split(entity, "/") {
+			if isValid, reason := ValidateEntityName(entityName); isValid == false {
+				regErr.add(deeperror.New(2714862375, fmt.Sprint("Invalid Enitity name:'", entityName, "' in '", entity, "' ", reason), nil))
+			}
+		}
+	}
+	if handler == nil {
+		regErr.add(deeperror.New(2714862376, fmt.Sprint("handler must not be nil. entity: ", entity, " method: ", method, " version: ", version, " action: ", action), nil))
+	}
+
+	versionStr := strings.TrimLeft(version, "vV")
+	versionStr = strings.TrimLeft(versionStr, "0")
+	if v64, err := strconv.ParseUint(versionStr, 10, VERSION_BIT_DEPTH); version != "" && (err != nil || v64 == 0) {
+		regErr.add(deeperror.New(2714862377, fmt.Sprint("Invalid version:'", version, "' entity: ", entity, " method: ", method, " action: ", action), err))
+	}
+
+	if options.RequiresAuth && options.Authenticator == nil {
+		regErr.add(deeperror.New(2714862378, fmt.Sprintf("Auth required route defined (%s %s), but no Authenticator was provided", method, entity), nil))
+	}
+
+	if regErr.hasProblems() {
+		return regErr
+	}
+
+	routePtr := newEntityRoute(entity)
+	routePtr.Method = method
 	routePtr.VersionStr = versionStr
+	routePtr.Action = strings.ToLower(action)
+	routePtr.Handler = handler
+	routePtr.HandlerName = options.HandlerName
+	if routePtr.HandlerName == "" {
+		routePtr.HandlerName = runtime.FuncForPC(reflect.ValueOf(handler).Pointer()).Name()
+	}
+	routePtr.ControllerName = options.ControllerName
+	routePtr.RequiresAuth = options.RequiresAuth
+	routePtr.Authenticator = options.Authenticator
+	routePtr.KeyPolicy = options.KeyPolicy
+	routePtr.KeyParser = options.KeyParser
+	routePtr.Timeout = options.Timeout
+	routePtr.MaxBodyBytes = options.MaxBodyBytes
+	routePtr.Metadata = options.Metadata
+	routePtr.Deprecation = options.Deprecation
+
+	router.lockRoutes()
+	defer router.unlockRoutes()
+	if router.commitRoutes(regErr, []*Route{routePtr}) == false {
+		return regErr
+	}
+	return nil
+}
+
+// entityPath may be a nested entity path, eg: "author/book"
+func newEntityRoute(entityPath string) *Route {
+	routePtr := new(Route)
+	entityNames := strings.Split(strings.Trim(entityPath, "/"), "/")
+	routePtr.ParentEntityNames = entityNames[:len(entityNames)-1]
+	routePtr.EntityName = entityNames[len(entityNames)-1]
+	return routePtr
+}
 
-	setRoute(router.RouteMap, routePtr.Method, routePtr.VersionStr, routePtr.Action, routePtr)
+// common to all route registration.  expects Method, VersionStr, EntityName, and Action to be populated.
+// The route is only added to the working set, it is served once published.
+func (router *Router) addRoute(routePtr *Route) {
+	if routePtr.EntityName == "" {
+		routePtr.Path = routePtr.Action
+	} else {
+		routePtr.Path = routePtr.parentsPath() + routePtr.EntityName + "/" + routePtr.Action
+	}
+	router.putWorkingRoute(routeKeyOf(routePtr), routePtr)
 }
 
 // Convenience method
 func (router *Router) AllRoutesCount() int {
-	return len(router.RouteMap)
+	return len(router.routes().routeMap)
 }
 
 // Basically just used for logging and debugging.
@@ -197,10 +498,11 @@ func (router *Router) AllRoutesDescription(addons ...string) []string {
 		suffix = strings.Join(addons[1:], " ")
 	}
 
-	count := len(router.RouteMap)
+	table := router.routes()
+	count := len(table.routeMap)
 
 	routeKeys := make([]string, 0, count)
-	for routeKey, _ := range router.RouteMap {
+	for routeKey, _ := range table.routeMap {
 		routeKeys = append(routeKeys, routeKey)
 	}
 	sort.Strings(routeKeys)
@@ -208,17 +510,20 @@ func (router *Router) AllRoutesDescription(addons ...string) []string {
 	lines := make([]string, 0, count)
 
 	for _, routeKey := range routeKeys {
-		routePtr := router.RouteMap[routeKey]
-		method, versionStr, entityName, action := routeComponents(routeKey)
+		routePtr := table.routeMap[routeKey]
+		method, _, entityName, action := routeComponents(routeKey)
 		handlerType := reflect.TypeOf(routePtr.Handler)
 
+		if entityName == "" {
+			entityName = "<NONE>"
+		}
 		if action == "" {
 			action = "<NONE>"
 		}
 
 		line := fmt.Sprintln(
 			method,
-			fmt.Sprintf("%vv%v/%v", router.BasePath, versionStr, routePtr.Path),
+			routePtr.fullPath(router.BasePath),
 			"Entity:", entityName,
 			"Action:", action,
 			routePtr.ControllerName,
@@ -231,6 +536,11 @@ func (router *Router) AllRoutesDescription(addons ...string) []string {
 
 		lines = append(lines, line)
 	}
+	for _, conflict := range table.conflicts {
+		line := "CONFLICT " + conflict.Existing.Method + " " + conflict.Existing.fullPath(router.BasePath) + " " + conflict.String() + "\n"
+		line = strings.Join([]string{prefix, line, suffix}, " ")
+		lines = append(lines, strings.TrimSpace(line))
+	}
 	// log.Println("104194464 End Routes")
 
 	// log.Println("104194464 RouteKeys")
@@ -283,6 +593,18 @@ func (router *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	ctx.w = w
 	ctx.Req = req
 	ctx.router = router
+	ctx.stdCtx = req.Context()
+	if router.isServing.Load() == false {
+		router.isServing.Store(true)
+	}
+	ctx.routes = router.routes() // this request's snapshot, unaffected by any registration while it is in flight
+	if req.Body != nil && req.Body != http.NoBody {
+		ctx.limitedBody = &limitedRequestBody{body: req.Body, limit: router.MaxBodyBytes}
+		req.Body = ctx.limitedBody
+	}
+
+	// cleanups run last, after the post processors
+	defer ctx.runCleanups()
 
 	// we use defer so our post processors are ALWAYS called.
 	defer func() {
@@ -311,6 +633,9 @@ func (router *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 
 	// 2. parse the route
 	endpoint, clientDeepErr, serverDeepErr := parsePath(req.URL, router.BasePath)
+	if len(ctx.routes.childEntities) > 0 {
+		nestEndpoint(&endpoint, ctx.routes.childEntities)
+	}
 	ctx.Endpoint = endpoint
 
 	if clientDeepErr != nil {
@@ -345,9 +670,54 @@ func (router *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 func (router *Router) handleContext(ctx *Context, req *http.Request) {
 
 	// 3. lookup the handler method
-	routePtr, err := getRoute(router.RouteMap, req.Method, ctx.Endpoint.VersionStr, ctx.Endpoint.EntityName, ctx.Endpoint.Action)
-	if err != nil || routePtr == nil {
-		ctx.SendSimpleErrorPayload(http.StatusNotFound, NotFoundErrorNumber, "404 Not Found")
+	if ctx.Endpoint.entityIndex > 0 && ctx.Endpoint.Version() == 0 {
+		// versioned path w/ an invalid version (eg: /v0/) should not match unversioned routes
+		ctx.SendSimpleErrorPayload(http.StatusNotFound, NotFoundErrorNumber, NotFoundPrefix)
+		return
+	}
+	router.negotiateVersion(ctx)
+	requestedEndpoint := ctx.Endpoint
+	routePtr, isDerivedHead := router.lookupContextRoute(ctx, req.Method)
+	if routePtr == nil && ctx.negotiatedMediaType != "" {
+		// The Accept header named a version, but the path may still be an unversioned route (eg: /healthz)
+		negotiatedEndpoint := ctx.Endpoint
+		ctx.Endpoint = requestedEndpoint
+		ctx.Endpoint.setVersionStr("")
+		routePtr, isDerivedHead = router.lookupContextRoute(ctx, req.Method)
+		if routePtr == nil {
+			ctx.Endpoint = negotiatedEndpoint
+		} else {
+			ctx.negotiatedMediaType = ""
+		}
+	}
+	if isDerivedHead {
+		// No explicit HeadHandler, derive one from the GET handler, keeping the headers but throwing away the body.
+		headWriter := newHeadResponseWriter(ctx.w)
+		ctx.w = headWriter
+		defer headWriter.finish()
+	}
+	if routePtr == nil {
+		router.handleUnroutedContext(ctx, req)
+		return
+	}
+	ctx.ResolvedVersionStr = routePtr.VersionStr
+
+	// 4. validate route
+	if router.handleDeprecation(ctx, routePtr) {
+		return
+	}
+	if routePtr.KeyPolicy == PrimaryKeyRequired && ctx.Endpoint.PrimaryKey == "" {
+		ctx.SendSimpleErrorPayload(http.StatusBadRequest, BadRequestMissingPrimaryKeyErrorNumber, BadRequestMissingPrimaryKeyPrefix)
+		return
+	}
+	if routePtr.KeyPolicy == PrimaryKeyForbidden && ctx.Endpoint.PrimaryKey != "" {
+		ctx.SendSimpleErrorPayload(http.StatusBadRequest, BadRequestExtraneousPrimaryKeyErrorNumber, BadRequestExtraneousPrimaryKeyPrefix)
+		return
+	}
+	if validatePrimaryKey(ctx, routePtr) == false {
+		return
+	}
+	if router.enforceBodyLimit(ctx, routePtr) == false {
 		return
 	}
 
@@ -369,6 +739,15 @@ func (router *Router) handleContext(ctx *Context, req *http.Request) {
 	}
 
 	// 7. call handler method
+	if routePtr.Timeout > 0 {
+		router.runHandlerWithTimeout(ctx, routePtr)
+	} else {
+		router.runHandler(ctx, routePtr)
+	}
+}
+
+// calls the handler, and writes out its result
+func (router *Router) runHandler(ctx *Context, routePtr *Route) {
 	routeHandlerResult := routePtr.Handler(ctx)
 	if routeHandlerResult.rerr != nil {
 		rtErr := routeHandlerResult.rerr
@@ -386,19 +765,72 @@ func (router *Router) handleContext(ctx *Context, req *http.Request) {
 	}
 }
 
+// isDerivedHead is true when a HEAD request is served by a GET route
+func (router *Router) lookupContextRoute(ctx *Context, method string) (routePtr *Route, isDerivedHead bool) {
+	if ctx.routes.hasRootRoutes {
+		router.resolveRootEndpoint(ctx)
+	}
+	routePtr = ctx.routes.lookupRoute(method, &ctx.Endpoint, router.VersionFallback)
+	if routePtr == nil && method == "HEAD" {
+		routePtr = ctx.routes.lookupRoute("GET", &ctx.Endpoint, router.VersionFallback)
+		isDerivedHead = routePtr != nil
+	}
+	return routePtr, isDerivedHead
+}
+
+// /v1/status parses as the "status" entity.  If there is no such entity, but there is an entity-less
+// "status" action, switch ctx.Endpoint over to the entity-less interpretation.
+func (router *Router) resolveRootEndpoint(ctx *Context) {
+	if len(ctx.Endpoint.Parents) > 0 || ctx.Endpoint.EntityName == "" {
+		return
+	}
+	version := ctx.Endpoint.Version()
+	if ctx.routes.tree.hasAnyRoute(version, &ctx.Endpoint, router.VersionFallback) {
+		return
+	}
+	rootEndpoint := ctx.Endpoint.rootEndpoint()
+	if ctx.routes.tree.hasAnyRoute(version, &rootEndpoint, router.VersionFallback) {
+		ctx.Endpoint = rootEndpoint
+	}
+}
+
+// No route for this method.  Distinguish between:
+// - unknown path: 404 Not Found
+// - known path, OPTIONS: 200 w/ Allow header (unless an OptionsHandler is defined, in which case we wouldn't be here)
+// - known path, wrong method: 405 Method Not Allowed w/ Allow header
+func (router *Router) handleUnroutedContext(ctx *Context, req *http.Request) {
+	allowedMethods := ctx.routes.tree.allowedMethods(ctx.Endpoint.Version(), &ctx.Endpoint, router.VersionFallback)
+	if len(allowedMethods) == 0 {
+		ctx.SendSimpleErrorPayload(http.StatusNotFound, NotFoundErrorNumber, NotFoundPrefix)
+		return
+	}
+
+	if i := sort.SearchStrings(allowedMethods, "OPTIONS"); i == len(allowedMethods) || allowedMethods[i] != "OPTIONS" {
+		// OPTIONS is always allowed, we answer it automatically
+		allowedMethods = append(allowedMethods, "OPTIONS")
+		sort.Strings(allowedMethods)
+	}
+	ctx.SetResponseHeader(HttpHeaderAllow, strings.Join(allowedMethods, ", "))
+
+	if req.Method == "OPTIONS" {
+		sendOkPayload(ctx)
+		return
+	}
+	ctx.SendSimpleErrorPayload(http.StatusMethodNotAllowed, MethodNotAllowedErrorNumber, MethodNotAllowedPrefix)
+}
+
 // RouteMap helpers
+// RouteMap is no longer used for dispatch, these are only used for the read-only view and AllRoutesDescription
 const ROUTE_MAP_SEPARATOR = "-{&|!?}-"
+const ROUTE_MAP_COLLECTION_SUFFIX = "collection"
 
-func getRoute(routeMap map[string]*Route, method, versionString, entityName, action string) (*Route, error) {
-	rk := routeKey(method, versionString, entityName, action)
-	// log.Println("rk", rk)
-	return routeMap[rk], nil
-}
-func setRoute(routeMap map[string]*Route, method, versionString, action string, route *Route) error {
-	rk := routeKey(method, versionString, route.EntityName, action)
-	// log.Println("rk", rk)
-	routeMap[rk] = route
-	return nil
+// collection routes are keyed separately, so that a ListHandler and a GetHandler can share a path
+func routeKeyOf(route *Route) string {
+	rk := routeKey(route.Method, route.VersionStr, route.EntityPath(), route.Action)
+	if route.KeyPolicy == PrimaryKeyForbidden {
+		rk += ROUTE_MAP_SEPARATOR + ROUTE_MAP_COLLECTION_SUFFIX
+	}
+	return rk
 }
 func routeKey(method, versionString, entityName, action string) string {
 	return routeKeyJoinString(method, versionString, entityName, action)
@@ -424,7 +856,7 @@ func routeKeyJoinString(method, versionString, entityName, action string) string
 }
 
 func routeKeyFormatString(method, versionString, entityName, action string) string {
-	return fmt.Sprintf("%s%s%s%s%d%s%s",
+	return fmt.Sprintf("%s%s%s%s%s%s%s",
 		strings.ToLower(entityName),
 		ROUTE_MAP_SEPARATOR,
 		method,
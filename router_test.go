@@ -2,11 +2,20 @@ package eprouter
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"runtime"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/amattn/deeperror"
 )
 
 func TestNothing(t *testing.T) {
@@ -196,12 +205,12 @@ func TestRouter(t *testing.T) {
 		"/api/v1/":      http.StatusNotFound,
 		"/api/v1/book/": http.StatusOK,
 
-		"/api/v2/book/":   http.StatusNotFound,
-		"/api/v3/book/":   http.StatusNotFound,
-		"/api/v1/author/": http.StatusNotFound,
+		"/api/v2/book/":   http.StatusMethodNotAllowed, // GET only
+		"/api/v3/book/":   http.StatusMethodNotAllowed, // GET only
+		"/api/v1/author/": http.StatusMethodNotAllowed, // GET only
 		"/api/v1/bogus/":  http.StatusNotFound,
 
-		"/api/v1/book/1": http.StatusBadRequest, // Create (POST) should never have a pk
+		"/api/v1/book/1": http.StatusOK, // no ListHandlerV1, so the handler gets the pk as before, see TestRouterPrimaryKeyPolicy
 	}
 
 	for urlsuffix, expectedStatusCode := range postURLAndStatusCodes {
@@ -227,9 +236,9 @@ func TestRouter(t *testing.T) {
 		"/api/v1/":       http.StatusNotFound,
 		"/api/v1/book/1": http.StatusOK,
 
-		"/api/v2/book/1":   http.StatusNotFound,
-		"/api/v3/book/1":   http.StatusNotFound,
-		"/api/v1/author/1": http.StatusNotFound,
+		"/api/v2/book/1":   http.StatusMethodNotAllowed, // GET only
+		"/api/v3/book/1":   http.StatusMethodNotAllowed, // GET only
+		"/api/v1/author/1": http.StatusMethodNotAllowed, // GET only
 		"/api/v1/bogus/1":  http.StatusNotFound,
 	}
 
@@ -334,3 +343,1565 @@ func BenchmarkRouteKeyFormatString(b *testing.B) {
 		routeKeyFormatString("GET", "1", "book", "all")
 	}
 }
+
+// The old way of dispatching: build the routeKey, then a map lookup.
+//As of 2026-10-16, Go 1.27
+//BenchmarkRouteMapLookup	 5538895	       232.9 ns/op	      56 B/op	       2 allocs/op
+func BenchmarkRouteMapLookup(b *testing.B) {
+	router := NewRouter()
+	router.RegisterEntity("book", &BookController{})
+	endpoint := Endpoint{VersionStr: "1", EntityName: "Book", Action: "login"}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if router.RouteMap[routeKey("GET", endpoint.VersionStr, endpoint.EntityName, endpoint.Action)] == nil {
+			b.Fatal("expected route")
+		}
+	}
+}
+
+// The precompiled dispatch tree, should be zero allocs/op
+//As of 2026-10-16, Go 1.27
+//BenchmarkRouteTreeLookup	 15433549	        81.01 ns/op	       0 B/op	       0 allocs/op
+func BenchmarkRouteTreeLookup(b *testing.B) {
+	router := NewRouter()
+	router.RegisterEntity("book", &BookController{})
+	endpoint := Endpoint{VersionStr: "1", EntityName: "book", Action: "login"}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if router.routes().tree.lookup("GET", endpoint.Version(), &endpoint) == nil {
+			b.Fatal("expected route")
+		}
+	}
+}
+
+//As of 2026-10-16, Go 1.27
+//BenchmarkRouteTreeLookupNested	 10926259	        97.65 ns/op	       0 B/op	       0 allocs/op
+func BenchmarkRouteTreeLookupNested(b *testing.B) {
+	router := NewRouter()
+	router.RegisterChildEntity("author", "book", &BookController{})
+	endpoint := Endpoint{VersionStr: "1", EntityName: "book", Action: "login", Parents: []ParentEntity{{"author", "7"}}}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if router.routes().tree.lookup("GET", endpoint.Version(), &endpoint) == nil {
+			b.Fatal("expected route")
+		}
+	}
+}
+
+//As of 2026-10-16, Go 1.27
+//BenchmarkRouteTreeLookupFallback	 17408338	        75.72 ns/op	       0 B/op	       0 allocs/op
+func BenchmarkRouteTreeLookupFallback(b *testing.B) {
+	router := NewRouter()
+	router.RegisterEntity("book", &BookController{})
+	endpoint := Endpoint{VersionStr: "17", EntityName: "book"}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if router.routes().tree.lookupFallback("GET", endpoint.Version(), &endpoint) == nil {
+			b.Fatal("expected route")
+		}
+	}
+}
+
+func TestRouterVersionFallback(t *testing.T) {
+	router := makeLibrary(t)
+	router.VersionFallback = true
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	getURLAndStatusCodes := map[string]int{
+		"/api/v1/book/1":   http.StatusOK,
+		"/api/v4/book/1":   http.StatusOK, // served by V3
+		"/api/v17/book/1":  http.StatusOK, // served by V3
+		"/api/v99/book/1":  http.StatusOK, // served by V18
+		"/api/v0/book/1":   http.StatusNotFound,
+		"/api/v5/author/1": http.StatusOK, // served by V1
+		"/api/v5/bogus/1":  http.StatusNotFound,
+	}
+
+	for urlsuffix, expectedStatusCode := range getURLAndStatusCodes {
+		response, err := http.Get(ts.URL + urlsuffix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		if response.StatusCode != expectedStatusCode {
+			t.Error("GET", urlsuffix, "expected ", expectedStatusCode, ", got", response.StatusCode)
+		}
+	}
+
+	// POST only has a V1, so v2 and beyond should resolve to it
+	response, err := http.Post(ts.URL+"/api/v2/book/", "text/plain", bytes.NewBufferString("Hello World!"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Error("POST /api/v2/book/ expected ", http.StatusOK, ", got", response.StatusCode)
+	}
+}
+
+func TestRouteTreeLookupFallback(t *testing.T) {
+	router := makeLibrary(t)
+
+	versionsAndExpecteds := map[VersionUint]string{
+		1:  "1",
+		2:  "2",
+		3:  "3",
+		4:  "3",
+		17: "3",
+		18: "18",
+		19: "18",
+	}
+
+	for requested, expected := range versionsAndExpecteds {
+		routePtr := router.routes().tree.lookupFallback("GET", requested, &Endpoint{EntityName: "book"})
+		if routePtr == nil {
+			t.Fatal("expected route for version", requested)
+		}
+		if routePtr.VersionStr != expected {
+			t.Error("version", requested, "expected resolved version", expected, "got", routePtr.VersionStr)
+		}
+	}
+}
+
+func TestRouterNestedEntity(t *testing.T) {
+	router := makeLibrary(t)
+	router.RegisterChildEntity("author", "book", &BookController{})
+	t.Log("All Routes:\n", router.AllRoutesSummary())
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	getURLAndStatusCodes := map[string]int{
+		"/api/v1/author/7/book/1":       http.StatusOK,
+		"/api/v1/author/7/book":         http.StatusOK,
+		"/api/v1/author/7/book/5":       http.StatusNotFound,
+		"/api/v2/author/7/book/1":       http.StatusOK,
+		"/api/v1/author/7/book/1/login": http.StatusUnauthorized,
+		"/api/v1/author/7/bogus/1":      http.StatusNotFound,
+		"/api/v1/book/1":                http.StatusOK,
+	}
+
+	for urlsuffix, expectedStatusCode := range getURLAndStatusCodes {
+		response, err := http.Get(ts.URL + urlsuffix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		if response.StatusCode != expectedStatusCode {
+			t.Error("GET", urlsuffix, "expected ", expectedStatusCode, ", got", response.StatusCode)
+		}
+	}
+
+	found := false
+	for _, line := range router.AllRoutesDescription() {
+		if strings.HasPrefix(line, "GET /api/v1/author/:author/book/login") {
+			found = true
+		}
+	}
+	if found == false {
+		t.Error("expected nested route in AllRoutesDescription\n", router.AllRoutesSummary())
+	}
+}
+
+func TestRouterHandle(t *testing.T) {
+	router := NewRouter()
+	router.BasePath = "/api/"
+	router.Handle("GET", "v1", "magazine", "", func(ctx *Context) RouteHandlerResult {
+		return ctx.MakeRouteHandlerResultOk()
+	}, nil)
+	router.Handle("post", "2", "magazine", "Renew", func(ctx *Context) RouteHandlerResult {
+		return ctx.MakeRouteHandlerResultOk()
+	}, &RouteOptions{HandlerName: "renewHandler"})
+	router.Handle("GET", "1", "magazine", "secret", func(ctx *Context) RouteHandlerResult {
+		return ctx.MakeRouteHandlerResultOk()
+	}, &RouteOptions{RequiresAuth: true, Authenticator: &BookController{}})
+	router.Handle("GET", "1", "author/magazine", "", func(ctx *Context) RouteHandlerResult {
+		if ctx.Endpoint.ParentKey("author") != "7" {
+			return ctx.MakeRouteHandlerResultNotFound(3795331016)
+		}
+		return ctx.MakeRouteHandlerResultOk()
+	}, nil)
+
+	if router.AllRoutesCount() != 4 {
+		t.Error("expected 4 routes, got", router.AllRoutesCount(), "\n", router.AllRoutesSummary())
+	}
+	summary := router.AllRoutesSummary()
+	if strings.Contains(summary, "POST /api/v2/magazine/renew") == false || strings.Contains(summary, "renewHandler") == false {
+		t.Error("expected explicit routes in AllRoutesSummary\n", summary)
+	}
+
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	getURLAndStatusCodes := map[string]int{
+		"/api/v1/magazine/1":          http.StatusOK,
+		"/api/v2/magazine/1":          http.StatusNotFound,
+		"/api/v1/magazine/1/secret":   http.StatusUnauthorized,
+		"/api/v1/author/7/magazine/1": http.StatusOK,
+		"/api/v1/author/8/magazine/1": http.StatusNotFound,
+	}
+	for urlsuffix, expectedStatusCode := range getURLAndStatusCodes {
+		response, err := http.Get(ts.URL + urlsuffix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		if response.StatusCode != expectedStatusCode {
+			t.Error("GET", urlsuffix, "expected ", expectedStatusCode, ", got", response.StatusCode)
+		}
+	}
+
+	response, err := http.Post(ts.URL+"/api/v2/magazine/1/renew", "text/plain", bytes.NewBufferString("Hello World!"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Error("POST /api/v2/magazine/1/renew expected ", http.StatusOK, ", got", response.StatusCode)
+	}
+}
+
+type ShelfController struct {
+}
+
+func (self *ShelfController) GetHandlerV1(ctx *Context) (Payload, error) {
+	if ctx.Endpoint.PrimaryKey == "missing" {
+		return nil, NewRouteError(http.StatusNotFound, ErrorInfo{ErrorNumber: 2193842201, ErrorMessage: "shelf not found"})
+	}
+	return BookPayload{PKey: 1, Name: "On the shelf"}, nil
+}
+func (self *ShelfController) GetHandlerV1Broken(ctx *Context) ([]Payload, error) {
+	return nil, fmt.Errorf("something went wrong")
+}
+func (self *ShelfController) PostHandlerV1(ctx *Context, book *BookPayload) ([]Payload, error) {
+	book.PKey = 42
+	return []Payload{*book, AuthorPayload{PKey: int64(book.AuthorId)}}, nil
+}
+func (self *ShelfController) PutHandlerV1(ctx *Context, book *BookPayload) RouteHandlerResult {
+	return ctx.MakeRouteHandlerResultPayloads(*book)
+}
+func (self *ShelfController) DeleteHandlerV1(ctx *Context, book BookPayload) (Payload, error) {
+	// invalid, body must be a pointer
+	return nil, nil
+}
+
+func TestRouterTypedHandlers(t *testing.T) {
+	router := NewRouter()
+	router.BasePath = "/api/"
+	router.RegisterEntity("shelf", &ShelfController{})
+	t.Log("All Routes:\n", router.AllRoutesSummary())
+
+	if router.AllRoutesCount() != 4 {
+		t.Error("expected 4 routes, got", router.AllRoutesCount())
+	}
+
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	type testCase struct {
+		method, urlsuffix, body string
+		expectedStatusCode      int
+		expectedPayloadCount    int
+	}
+	testCases := []testCase{
+		{"GET", "/api/v1/shelf/1", "", http.StatusOK, 1},
+		{"GET", "/api/v1/shelf/missing", "", http.StatusNotFound, 0},
+		{"GET", "/api/v1/shelf/1/broken", "", http.StatusInternalServerError, 0},
+		{"POST", "/api/v1/shelf", `{"Name":"New Book","AuthorId":7}`, http.StatusOK, 2},
+		{"POST", "/api/v1/shelf", `Hello World!`, http.StatusBadRequest, 0},
+		{"POST", "/api/v1/shelf", ``, http.StatusBadRequest, 0},
+		{"POST", "/api/v1/shelf", `null`, http.StatusBadRequest, 0},
+		{"PUT", "/api/v1/shelf/1", `{"PKey":1,"Name":"Renamed"}`, http.StatusOK, 1},
+		{"DELETE", "/api/v1/shelf/1", ``, http.StatusMethodNotAllowed, 0},
+	}
+
+	for _, tc := range testCases {
+		req, _ := http.NewRequest(tc.method, ts.URL+tc.urlsuffix, bytes.NewBufferString(tc.body))
+		response, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bodyBytes, _ := ioutil.ReadAll(response.Body)
+		response.Body.Close()
+		if response.StatusCode != tc.expectedStatusCode {
+			t.Error(tc.method, tc.urlsuffix, "expected ", tc.expectedStatusCode, ", got", response.StatusCode, string(bodyBytes))
+			continue
+		}
+
+		pw, err := UnmarshalPayloadWrapper(bodyBytes, BookPayload{}, AuthorPayload{})
+		if err != nil {
+			t.Error(tc.method, tc.urlsuffix, "unexpected error returned from UnmarshalPayloadWrapper", err)
+			continue
+		}
+		count := 0
+		for _, payloadList := range pw.Payloads {
+			count += len(payloadList)
+		}
+		if count != tc.expectedPayloadCount {
+			t.Error(tc.method, tc.urlsuffix, "expected", tc.expectedPayloadCount, "payloads, got", count, string(bodyBytes))
+		}
+	}
+}
+
+func TestRouterGenericBodyHandlers(t *testing.T) {
+	shelf := &ShelfController{}
+	router := NewRouter()
+	router.BasePath = "/api/"
+	router.Handle("POST", "1", "shelf", "", PayloadsBodyHandler(shelf.PostHandlerV1), nil)
+	router.Handle("PUT", "1", "shelf", "", BodyHandler(shelf.PutHandlerV1), nil)
+	router.Handle("PATCH", "1", "shelf", "", PayloadBodyHandler(func(ctx *Context, book *BookPayload) (Payload, error) {
+		return *book, nil
+	}), nil)
+
+	type testCase struct {
+		method, body         string
+		expectedStatusCode   int
+		expectedPayloadCount int
+	}
+	testCases := []testCase{
+		{"POST", `{"Name":"New Book","AuthorId":7}`, http.StatusOK, 2},
+		{"POST", `Hello World!`, http.StatusBadRequest, 0},
+		{"PUT", `{"PKey":1,"Name":"Renamed"}`, http.StatusOK, 1},
+		{"PUT", ``, http.StatusBadRequest, 0},
+		{"PUT", `null`, http.StatusBadRequest, 0},
+		{"PATCH", `{"PKey":1,"Name":"Renamed"}`, http.StatusOK, 1},
+	}
+
+	for _, tc := range testCases {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(tc.method, "/api/v1/shelf", bytes.NewBufferString(tc.body)))
+		if w.Code != tc.expectedStatusCode {
+			t.Error(tc.method, "expected", tc.expectedStatusCode, "got", w.Code, w.Body.String())
+			continue
+		}
+		if tc.expectedPayloadCount == 0 {
+			continue
+		}
+		pw, err := UnmarshalPayloadWrapper(w.Body.Bytes(), BookPayload{}, AuthorPayload{})
+		if err != nil {
+			t.Error(tc.method, "unexpected error returned from UnmarshalPayloadWrapper", err)
+			continue
+		}
+		count := 0
+		for _, payloadList := range pw.Payloads {
+			count += len(payloadList)
+		}
+		if count != tc.expectedPayloadCount {
+			t.Error(tc.method, "expected", tc.expectedPayloadCount, "payloads, got", count, w.Body.String())
+		}
+	}
+}
+
+// true if any caller is in package reflect
+func calledViaReflect() bool {
+	pcs := make([]uintptr, 64)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs)])
+	for {
+		frame, more := frames.Next()
+		if strings.HasPrefix(frame.Function, "reflect.") {
+			return true
+		}
+		if more == false {
+			return false
+		}
+	}
+}
+
+var calledViaReflectError = NewRouteError(http.StatusInternalServerError, ErrorInfo{ErrorNumber: 2193842202, ErrorMessage: "called via reflect"})
+
+// value receivers, registered both as a value and as a pointer
+type StackController struct {
+	Name string
+}
+
+func (self StackController) GetHandlerV1(ctx *Context) RouteHandlerResult {
+	if calledViaReflect() {
+		return ctx.MakeRouteHandlerResultFromError(calledViaReflectError)
+	}
+	return ctx.MakeRouteHandlerResultPayloads(BookPayload{Name: self.Name})
+}
+func (self StackController) PostHandlerV1(ctx *Context, book *BookPayload) (Payload, error) {
+	if calledViaReflect() {
+		return nil, calledViaReflectError
+	}
+	book.Name = self.Name + " " + book.Name
+	return *book, nil
+}
+
+func TestRouterHandlersDontReflect(t *testing.T) {
+	router := NewRouter()
+	router.BasePath = "/api/"
+	router.RegisterEntity("shelf", &ShelfController{})
+	router.RegisterEntity("stack", StackController{Name: "value"})
+	router.RegisterEntity("pile", &StackController{Name: "pointer"})
+	router.AddEntityRoute("heap", "", "PostHandlerV1", func(ctx *Context, book *BookPayload) ([]Payload, error) {
+		if calledViaReflect() {
+			return nil, calledViaReflectError
+		}
+		return []Payload{*book}, nil
+	}, nil)
+
+	type testCase struct {
+		method, urlsuffix, body string
+		expectedName            string
+	}
+	testCases := []testCase{
+		{"GET", "/api/v1/stack/1", "", "value"},
+		{"POST", "/api/v1/stack", `{"Name":"book"}`, "value book"},
+		{"GET", "/api/v1/pile/1", "", "pointer"},
+		{"POST", "/api/v1/pile", `{"Name":"book"}`, "pointer book"},
+		{"POST", "/api/v1/heap", `{"Name":"book"}`, "book"},
+	}
+	for _, tc := range testCases {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(tc.method, tc.urlsuffix, bytes.NewBufferString(tc.body)))
+		if w.Code != http.StatusOK {
+			t.Error(tc.method, tc.urlsuffix, "expected", http.StatusOK, "got", w.Code, w.Body.String())
+			continue
+		}
+		pw, err := UnmarshalPayloadWrapper(w.Body.Bytes(), BookPayload{})
+		if err != nil {
+			t.Error(tc.method, tc.urlsuffix, "unexpected error returned from UnmarshalPayloadWrapper", err)
+			continue
+		}
+		for _, payloadList := range pw.Payloads {
+			for _, payload := range payloadList {
+				if book, _ := payload.(*BookPayload); book == nil || book.Name != tc.expectedName {
+					t.Error(tc.method, tc.urlsuffix, "expected", tc.expectedName, "got", w.Body.String())
+				}
+			}
+		}
+	}
+}
+
+// a typed handler bound at registration, vs. the generic wrapper
+//As of 2026-10-16, Go 1.27
+//BenchmarkTypedBodyHandlerRegistered	  948541	      1296 ns/op	     520 B/op	       7 allocs/op
+//BenchmarkTypedBodyHandlerGeneric	 1000000	      1258 ns/op	     520 B/op	       7 allocs/op
+func BenchmarkTypedBodyHandlerRegistered(b *testing.B) {
+	_, _, handler := ValidateHandler((&ShelfController{}).PutHandlerV1)
+	benchmarkTypedBodyHandler(b, handler)
+}
+
+func BenchmarkTypedBodyHandlerGeneric(b *testing.B) {
+	benchmarkTypedBodyHandler(b, BodyHandler((&ShelfController{}).PutHandlerV1))
+}
+
+func benchmarkTypedBodyHandler(b *testing.B, handler RouteHandler) {
+	ctx := new(Context)
+	for i := 0; i < b.N; i++ {
+		ctx.cachedRequestBody = []byte(`{"PKey":1,"Name":"Renamed"}`)
+		handler(ctx)
+	}
+}
+
+type MagazineController struct {
+}
+
+func (self *MagazineController) GetHandlerV1(ctx *Context) RouteHandlerResult {
+	return ctx.MakeRouteHandlerResultOk()
+}
+func (self *MagazineController) OptionsHandlerV2(ctx *Context) RouteHandlerResult {
+	ctx.SetResponseHeader(HttpHeaderAllow, "GET")
+	return ctx.MakeRouteHandlerResultGenericJSON(map[string]string{"custom": "options"})
+}
+func (self *MagazineController) GetHandlerV2(ctx *Context) RouteHandlerResult {
+	return ctx.MakeRouteHandlerResultOk()
+}
+
+func TestRouterMethodNotAllowed(t *testing.T) {
+	router := makeLibrary(t)
+	router.RegisterEntity("magazine", &MagazineController{})
+	router.RegisterEntity("pamphlet", &PamphletController{}) // an explicit HeadHandler elsewhere doesn't hide the derived ones
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	type testCase struct {
+		method, urlsuffix  string
+		expectedStatusCode int
+		expectedAllow      string
+	}
+	testCases := []testCase{
+		{"DELETE", "/api/v1/book/1", http.StatusOK, ""},
+		{"DELETE", "/api/v2/book/1", http.StatusMethodNotAllowed, "GET, HEAD, OPTIONS"},
+		{"PATCH", "/api/v1/book/1", http.StatusMethodNotAllowed, "DELETE, GET, HEAD, OPTIONS, POST, PUT"},
+		{"DELETE", "/api/v1/book/1/login", http.StatusMethodNotAllowed, "GET, HEAD, OPTIONS"},
+		{"DELETE", "/api/v1/bogus/1", http.StatusNotFound, ""},
+		{"OPTIONS", "/api/v1/book/1", http.StatusOK, "DELETE, GET, HEAD, OPTIONS, POST, PUT"},
+		{"OPTIONS", "/api/v1/author", http.StatusOK, "GET, HEAD, OPTIONS"},
+		{"OPTIONS", "/api/v1/bogus", http.StatusNotFound, ""},
+		{"OPTIONS", "/api/v1/magazine", http.StatusOK, "GET, HEAD, OPTIONS"},
+		{"OPTIONS", "/api/v2/magazine", http.StatusOK, "GET"}, // custom OptionsHandlerV2
+		{"DELETE", "/api/v2/magazine", http.StatusMethodNotAllowed, "GET, HEAD, OPTIONS"},
+		{"OPTIONS", "/api/v1/pamphlet/1", http.StatusOK, "GET, HEAD, OPTIONS"},
+		{"OPTIONS", "/api/v2/pamphlet/1", http.StatusOK, "GET, HEAD, OPTIONS"},
+	}
+
+	for _, tc := range testCases {
+		req, _ := http.NewRequest(tc.method, ts.URL+tc.urlsuffix, nil)
+		response, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		if response.StatusCode != tc.expectedStatusCode {
+			t.Error(tc.method, tc.urlsuffix, "expected ", tc.expectedStatusCode, ", got", response.StatusCode)
+		}
+		if allow := response.Header.Get(HttpHeaderAllow); allow != tc.expectedAllow {
+			t.Error(tc.method, tc.urlsuffix, "expected Allow:", tc.expectedAllow, ", got", allow)
+		}
+	}
+}
+
+type PamphletController struct {
+}
+
+func (self *PamphletController) GetHandlerV1(ctx *Context) RouteHandlerResult {
+	ctx.SetResponseHeader("ETag", `"v1"`)
+	return ctx.MakeRouteHandlerResultPayloads(BookPayload{PKey: 1, Name: "A Pamphlet"})
+}
+func (self *PamphletController) GetHandlerV2(ctx *Context) RouteHandlerResult {
+	return ctx.MakeRouteHandlerResultPayloads(BookPayload{PKey: 2, Name: "Another Pamphlet"})
+}
+func (self *PamphletController) HeadHandlerV2(ctx *Context) RouteHandlerResult {
+	ctx.SetResponseHeader("ETag", `"explicit"`)
+	return ctx.MakeRouteHandlerResultOk()
+}
+
+func TestRouterHead(t *testing.T) {
+	router := NewRouter()
+	router.BasePath = "/api/"
+	router.RegisterEntity("pamphlet", &PamphletController{})
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	getResponse, err := http.Get(ts.URL + "/api/v1/pamphlet/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	getBody, _ := ioutil.ReadAll(getResponse.Body)
+	getResponse.Body.Close()
+
+	headResponse, err := http.Head(ts.URL + "/api/v1/pamphlet/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	headBody, _ := ioutil.ReadAll(headResponse.Body)
+	headResponse.Body.Close()
+
+	if headResponse.StatusCode != http.StatusOK {
+		t.Error("HEAD expected", http.StatusOK, "got", headResponse.StatusCode)
+	}
+	if len(headBody) != 0 {
+		t.Error("HEAD expected empty body, got", string(headBody))
+	}
+	if headResponse.ContentLength != int64(len(getBody)) {
+		t.Error("HEAD expected Content-Length", len(getBody), "got", headResponse.ContentLength)
+	}
+	if ct := headResponse.Header.Get(HttpHeaderContentType); ct != HttpHeaderContentTypeJSON {
+		t.Error("HEAD expected Content-Type", HttpHeaderContentTypeJSON, "got", ct)
+	}
+	if etag := headResponse.Header.Get("ETag"); etag != `"v1"` {
+		t.Error("HEAD expected ETag from GET handler, got", etag)
+	}
+
+	// explicit HeadHandler takes precedence
+	headResponse, err = http.Head(ts.URL + "/api/v2/pamphlet/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	headResponse.Body.Close()
+	if etag := headResponse.Header.Get("ETag"); etag != `"explicit"` {
+		t.Error("HEAD expected ETag from HeadHandler, got", etag)
+	}
+
+	headResponse, err = http.Head(ts.URL + "/api/v1/bogus/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	headResponse.Body.Close()
+	if headResponse.StatusCode != http.StatusNotFound {
+		t.Error("HEAD /api/v1/bogus/1 expected", http.StatusNotFound, "got", headResponse.StatusCode)
+	}
+}
+
+func TestRouterRootRoutes(t *testing.T) {
+	router := makeLibrary(t)
+	router.VersionFallback = true
+	makeHandler := func(name string) RouteHandler {
+		return func(ctx *Context) RouteHandlerResult {
+			return ctx.MakeRouteHandlerResultGenericJSON(map[string]string{"handler": name, "action": ctx.Endpoint.Action})
+		}
+	}
+	router.Handle("GET", "1", "", "status", makeHandler("status"), nil)
+	router.Handle("GET", "1", "", "", makeHandler("index"), nil)
+	router.Handle("GET", "", "", "healthz", makeHandler("healthz"), nil)
+	router.Handle("GET", "", "", "book", makeHandler("rootbook"), nil)
+	router.Handle("GET", "", "magazine", "", makeHandler("magazine"), nil)
+	t.Log("All Routes:\n", router.AllRoutesSummary())
+
+	summary := router.AllRoutesSummary()
+	if strings.Contains(summary, "GET /api/healthz ") == false || strings.Contains(summary, "GET /api/v1/status ") == false {
+		t.Error("expected root routes in AllRoutesSummary\n", summary)
+	}
+
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	type testCase struct {
+		method, urlsuffix  string
+		expectedStatusCode int
+		expectedHandler    string
+	}
+	testCases := []testCase{
+		{"GET", "/api/v1/status", http.StatusOK, "status"},
+		{"GET", "/api/v1/status/extra", http.StatusOK, "status"},
+		{"GET", "/api/v3/status", http.StatusOK, "status"}, // fallback
+		{"GET", "/api/v1", http.StatusOK, "index"},
+		{"GET", "/api/v1/", http.StatusOK, "index"},
+		{"GET", "/api/healthz", http.StatusOK, "healthz"},
+		{"GET", "/api/v1/healthz", http.StatusNotFound, ""},
+		{"GET", "/api/v0/healthz", http.StatusNotFound, ""},
+		{"GET", "/api/magazine/1", http.StatusOK, "magazine"},
+		{"GET", "/api/book", http.StatusOK, "rootbook"},
+		{"GET", "/api/v1/book/1", http.StatusOK, ""}, // the entity wins over root actions
+		{"POST", "/api/v1/status", http.StatusMethodNotAllowed, ""},
+		{"GET", "/api/bogus", http.StatusNotFound, ""},
+		{"GET", "/api/", http.StatusNotFound, ""},
+	}
+
+	for _, tc := range testCases {
+		req, _ := http.NewRequest(tc.method, ts.URL+tc.urlsuffix, nil)
+		response, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bodyBytes, _ := ioutil.ReadAll(response.Body)
+		response.Body.Close()
+		if response.StatusCode != tc.expectedStatusCode {
+			t.Error(tc.method, tc.urlsuffix, "expected ", tc.expectedStatusCode, ", got", response.StatusCode, string(bodyBytes))
+			continue
+		}
+		if tc.expectedHandler != "" {
+			result := map[string]string{}
+			json.Unmarshal(bodyBytes, &result)
+			if result["handler"] != tc.expectedHandler {
+				t.Error(tc.method, tc.urlsuffix, "expected handler", tc.expectedHandler, ", got", string(bodyBytes))
+			}
+		}
+	}
+}
+
+type BrokenController struct {
+}
+
+func (self *BrokenController) GetHandlerV1(ctx *Context) RouteHandlerResult {
+	return ctx.MakeRouteHandlerResultOk()
+}
+func (self *BrokenController) AuthGetHandlerV1Secret(ctx *Context) RouteHandlerResult {
+	// invalid, BrokenController doesn't implement AuthHandler
+	return ctx.MakeRouteHandlerResultOk()
+}
+func (self *BrokenController) AuthPutHandlerV1(ctx *Context) RouteHandlerResult {
+	// invalid, BrokenController doesn't implement AuthHandler
+	return ctx.MakeRouteHandlerResultOk()
+}
+func (self *BrokenController) FetchHandlerV1(ctx *Context) RouteHandlerResult {
+	// skipped, unknown method
+	return ctx.MakeRouteHandlerResultOk()
+}
+
+func TestRouterRegistrationErrors(t *testing.T) {
+	router := NewRouter()
+
+	err := router.TryRegisterEntity("broken", &BrokenController{})
+	regErr, ok := err.(*RegistrationError)
+	if ok == false {
+		t.Fatalf("expected *RegistrationError, got %T %v", err, err)
+	}
+	if len(regErr.Problems) != 2 {
+		t.Error("expected 2 problems, got", len(regErr.Problems), regErr)
+	}
+	if router.AllRoutesCount() != 0 {
+		t.Error("expected no routes to be registered for a controller with problems, got", router.AllRoutesCount())
+	}
+	if _, exists := router.Controllers["broken"]; exists {
+		t.Error("expected controller with problems to not be registered")
+	}
+
+	if err := router.TryRegisterEntity("", &AuthorController{}); err == nil {
+		t.Error("expected error for empty entity name")
+	}
+	if err := router.TryRegisterEntity("author", nil); err == nil {
+		t.Error("expected error for nil controller")
+	}
+	if err := router.TryRegisterChildEntity("", "book", &BookController{}); err == nil {
+		t.Error("expected error for empty parent entity name")
+	}
+	if err := router.TryHandle("GET", "vX", "book", "", nil, &RouteOptions{RequiresAuth: true}); err == nil {
+		t.Error("expected error for invalid TryHandle")
+	} else if len(err.(*RegistrationError).Problems) != 3 {
+		t.Error("expected 3 problems, got", err)
+	}
+
+	// not strict, skipped handlers are only logged
+	if err := router.TryRegisterEntity("shelf", &ShelfController{}); err != nil {
+		t.Error("unexpected error", err)
+	}
+
+	strictRouter := NewRouter()
+	strictRouter.StrictRegistration = true
+	if err := strictRouter.TryRegisterEntity("shelf", &ShelfController{}); err == nil {
+		t.Error("expected strict registration to fail on DeleteHandlerV1")
+	}
+	if err := strictRouter.TryRegisterEntity("book", &BookController{}); err != nil {
+		t.Error("unexpected error", err)
+	}
+}
+
+func TestRouterHandleMethod(t *testing.T) {
+	router := NewRouter()
+	router.BasePath = "/api/"
+	okHandler := func(ctx *Context) RouteHandlerResult { return ctx.MakeRouteHandlerResultOk() }
+
+	for _, method := range []string{"", "GET /", "GE T", "GET\n", "GÉT", "(GET)"} {
+		if err := router.TryHandle(method, "1", "book", "", okHandler, nil); err == nil {
+			t.Errorf("expected error for method %q", method)
+		} else if problems := err.(*RegistrationError).Problems; len(problems) != 1 || problems[0].Num != 2714862379 {
+			t.Errorf("expected an invalid method problem for %q, got %v", method, err)
+		}
+	}
+	if router.AllRoutesCount() != 0 {
+		t.Error("expected no routes for invalid methods, got", router.AllRoutesSummary())
+	}
+
+	// upper cased, like RegisterEntity's
+	if err := router.TryHandle("propfind", "1", "book", "", okHandler, nil); err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("PROPFIND", "/api/v1/book/1", nil))
+	if w.Code != http.StatusOK {
+		t.Error("PROPFIND expected", http.StatusOK, "got", w.Code, w.Body.String())
+	}
+}
+
+type ConflictingController struct {
+}
+
+func (self *ConflictingController) GetHandlerV1(ctx *Context) RouteHandlerResult {
+	return ctx.MakeRouteHandlerResultOk()
+}
+func (self *ConflictingController) GetHandlerV01(ctx *Context) RouteHandlerResult {
+	return ctx.MakeRouteHandlerResultOk()
+}
+
+func TestRouterConflicts(t *testing.T) {
+	// default, warn
+	router := NewRouter()
+	if err := router.TryRegisterEntity("book", &ConflictingController{}); err != nil {
+		t.Error("unexpected error", err)
+	}
+	if len(router.Conflicts) != 1 {
+		t.Fatal("expected 1 conflict, got", len(router.Conflicts))
+	}
+	conflictString := router.Conflicts[0].String()
+	if strings.Contains(conflictString, "GetHandlerV1") == false || strings.Contains(conflictString, "GetHandlerV01") == false {
+		t.Error("expected both handler names in conflict, got", conflictString)
+	}
+	if strings.Contains(router.AllRoutesSummary(), "CONFLICT GET v1/book/") == false {
+		t.Error("expected conflict in AllRoutesSummary, got", router.AllRoutesSummary())
+	}
+
+	// conflicts across controllers
+	router.TryRegisterEntity("book", &BookController{})
+	if len(router.Conflicts) != 2 {
+		t.Error("expected 2 conflicts, got", len(router.Conflicts))
+	}
+	if router.RouteMap[routeKey("GET", "1", "book", "")].ControllerName != "*eprouter.BookController" {
+		t.Error("expected last registered to win")
+	}
+
+	// last wins
+	router = NewRouter()
+	router.ConflictPolicy = ConflictPolicyLastWins
+	if err := router.TryRegisterEntity("book", &ConflictingController{}); err != nil {
+		t.Error("unexpected error", err)
+	}
+	if len(router.Conflicts) != 1 {
+		t.Error("expected 1 conflict, got", len(router.Conflicts))
+	}
+
+	// error
+	router = NewRouter()
+	router.ConflictPolicy = ConflictPolicyError
+	if err := router.TryRegisterEntity("book", &ConflictingController{}); err == nil {
+		t.Error("expected conflict error")
+	}
+	if router.AllRoutesCount() != 0 {
+		t.Error("expected no routes, got", router.AllRoutesCount())
+	}
+	router.RegisterEntity("book", &BookController{})
+	err := router.TryHandle("GET", "1", "book", "", func(ctx *Context) RouteHandlerResult { return ctx.MakeRouteHandlerResultOk() }, nil)
+	if err == nil {
+		t.Error("expected conflict error")
+	}
+	if len(router.Conflicts) != 0 {
+		t.Error("expected no recorded conflicts, got", len(router.Conflicts))
+	}
+}
+
+type DocumentedController struct {
+}
+
+func (self *DocumentedController) RouteMetadata(handlerName string) map[string]string {
+	if handlerName == "GetHandlerV1" {
+		return map[string]string{"summary": "fetch a pamphlet"}
+	}
+	return nil
+}
+func (self *DocumentedController) GetHandlerV1(ctx *Context) RouteHandlerResult {
+	return ctx.MakeRouteHandlerResultOk()
+}
+func (self *DocumentedController) DeleteHandlerV1(ctx *Context) RouteHandlerResult {
+	return ctx.MakeRouteHandlerResultOk()
+}
+
+func TestRouterRoutes(t *testing.T) {
+	router := makeLibrary(t)
+	router.RegisterEntity("documented", &DocumentedController{})
+	router.Handle("GET", "", "", "healthz", func(ctx *Context) RouteHandlerResult { return ctx.MakeRouteHandlerResultOk() }, &RouteOptions{Metadata: map[string]string{"internal": "true"}})
+	router.HandleRoutesEndpoint("", "", "routes", nil)
+	router.Handle("GET", "18", "edition", "", func(ctx *Context) RouteHandlerResult { return ctx.MakeRouteHandlerResultOk() }, nil)
+	router.Handle("GET", "2", "edition", "", func(ctx *Context) RouteHandlerResult { return ctx.MakeRouteHandlerResultOk() }, nil)
+
+	infos := router.Routes()
+	if len(infos) != router.AllRoutesCount() {
+		t.Error("expected", router.AllRoutesCount(), "routes, got", len(infos))
+	}
+
+	byPath := map[string]RouteInfo{}
+	for _, info := range infos {
+		byPath[info.Method+" "+info.Path] = info
+	}
+
+	login := byPath["GET /api/v1/book/login"]
+	if login.RequiresAuth == false || login.Entity != "book" || login.Action != "login" || login.Version != "1" || login.HandlerName != "AuthGetHandlerV1Login" || login.ControllerName != "*eprouter.BookController" {
+		t.Errorf("unexpected login route %+v", login)
+	}
+	if byPath["GET /api/v1/documented/"].Metadata["summary"] != "fetch a pamphlet" {
+		t.Errorf("expected controller metadata, got %+v", byPath["GET /api/v1/documented/"])
+	}
+	if byPath["DELETE /api/v1/documented/"].Metadata != nil {
+		t.Errorf("expected no metadata, got %+v", byPath["DELETE /api/v1/documented/"])
+	}
+	if byPath["GET /api/healthz"].Metadata["internal"] != "true" || byPath["GET /api/healthz"].Version != "" {
+		t.Errorf("expected handle metadata, got %+v", byPath["GET /api/healthz"])
+	}
+
+	// method, then numeric version, then entity, then action
+	editionVersions := []string{}
+	for i, info := range infos {
+		if info.Entity == "edition" {
+			editionVersions = append(editionVersions, info.Version)
+		}
+		if i == 0 {
+			continue
+		}
+		previous := infos[i-1]
+		previousVersion, _ := strconv.Atoi(previous.Version)
+		version, _ := strconv.Atoi(info.Version)
+		switch {
+		case previous.Method != info.Method:
+			if previous.Method > info.Method {
+				t.Error("expected routes sorted by method, got", previous.Method, "before", info.Method)
+			}
+		case previousVersion != version:
+			if previousVersion > version {
+				t.Error("expected routes sorted by version, got", previous.Path, "before", info.Path)
+			}
+		case previous.Entity != info.Entity:
+			if previous.Entity > info.Entity {
+				t.Error("expected routes sorted by entity, got", previous.Path, "before", info.Path)
+			}
+		case previous.Action > info.Action:
+			t.Error("expected routes sorted by action, got", previous.Path, "before", info.Path)
+		}
+	}
+	if strings.Join(editionVersions, ",") != "2,18" {
+		t.Error("expected edition versions in numeric order, got", editionVersions)
+	}
+
+	// copies, not views
+	infos[0].Metadata = map[string]string{"mutated": "true"}
+	if router.Routes()[0].Metadata["mutated"] != "" {
+		t.Error("expected Routes() to return copies")
+	}
+
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+	response, err := http.Get(ts.URL + "/api/routes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Fatal("expected 200, got", response.StatusCode)
+	}
+	wrapper := struct {
+		Payloads map[string][]RouteInfo
+	}{}
+	if err := json.NewDecoder(response.Body).Decode(&wrapper); err != nil {
+		t.Fatal(err)
+	}
+	if len(wrapper.Payloads[RouteInfoPayloadType]) != len(infos) {
+		t.Error("expected", len(infos), "routes, got", len(wrapper.Payloads[RouteInfoPayloadType]))
+	}
+}
+
+func TestRouterDeprecation(t *testing.T) {
+	router := makeLibrary(t)
+	router.VersionFallback = true
+	deprecated := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	future := time.Now().Add(24 * time.Hour)
+	past := time.Now().Add(-24 * time.Hour)
+
+	router.DeprecateVersion("v1", Deprecation{Date: deprecated, Sunset: future, Link: "https://example.com/api/v2/"})
+	router.DeprecateEntity("1", "author", Deprecation{Sunset: past, Alert: "authors moved"})
+	router.DeprecateRoute("GET", "2", "book", "", Deprecation{Link: "https://example.com/api/v3/book"})
+
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	type testCase struct {
+		urlsuffix           string
+		expectedStatusCode  int
+		expectedDeprecation string
+		expectedSunset      string
+		expectedLink        string
+	}
+	testCases := []testCase{
+		{"/api/v1/book/1", http.StatusOK, "@1735689600", future.UTC().Format(http.TimeFormat), `<https://example.com/api/v2/>; rel="successor-version"`},
+		{"/api/v1/author/1", http.StatusGone, "true", past.UTC().Format(http.TimeFormat), ""},
+		{"/api/v2/book/1", http.StatusOK, "true", "", `<https://example.com/api/v3/book>; rel="successor-version"`},
+		{"/api/v2/author/1", http.StatusOK, "", "", ""}, // falls back to the v1 handler, but v2 isn't deprecated
+		{"/api/v3/book/1", http.StatusOK, "", "", ""},
+	}
+
+	for _, tc := range testCases {
+		response, err := http.Get(ts.URL + tc.urlsuffix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bodyBytes, _ := ioutil.ReadAll(response.Body)
+		response.Body.Close()
+		if response.StatusCode != tc.expectedStatusCode {
+			t.Error(tc.urlsuffix, "expected ", tc.expectedStatusCode, ", got", response.StatusCode, string(bodyBytes))
+		}
+		if response.Header.Get("Deprecation") != tc.expectedDeprecation {
+			t.Error(tc.urlsuffix, "expected Deprecation", tc.expectedDeprecation, ", got", response.Header.Get("Deprecation"))
+		}
+		if response.Header.Get("Sunset") != tc.expectedSunset {
+			t.Error(tc.urlsuffix, "expected Sunset", tc.expectedSunset, ", got", response.Header.Get("Sunset"))
+		}
+		if response.Header.Get("Link") != tc.expectedLink {
+			t.Error(tc.urlsuffix, "expected Link", tc.expectedLink, ", got", response.Header.Get("Link"))
+		}
+		if response.StatusCode == http.StatusGone {
+			pw := new(PayloadWrapper)
+			json.Unmarshal(bodyBytes, pw)
+			if pw.ErrorNumber != GoneErrorNumber || pw.Alert != "authors moved" {
+				t.Error(tc.urlsuffix, "expected Gone payload w/ alert, got", string(bodyBytes))
+			}
+		}
+	}
+
+	for _, info := range router.Routes() {
+		switch {
+		case info.Method == "GET" && info.Path == "/api/v1/author/":
+			if info.Deprecated == false || info.Sunset == "" {
+				t.Errorf("expected deprecated author route, got %+v", info)
+			}
+		case info.Method == "GET" && info.Path == "/api/v2/book/":
+			if info.Deprecated == false || info.SuccessorLink != "https://example.com/api/v3/book" {
+				t.Errorf("expected deprecated v2 book route, got %+v", info)
+			}
+		case info.Method == "GET" && info.Path == "/api/v3/book/":
+			if info.Deprecated {
+				t.Errorf("expected v3 book route not to be deprecated, got %+v", info)
+			}
+		}
+	}
+}
+
+func TestRouterTryDeprecate(t *testing.T) {
+	router := makeLibrary(t)
+	router.ConflictPolicy = ConflictPolicyLastWins
+
+	if err := router.TryDeprecateVersion("bogus", Deprecation{}); err == nil {
+		t.Error("expected an error for an invalid version")
+	}
+	if err := router.TryDeprecateEntity("v0", "book", Deprecation{}); err == nil {
+		t.Error("expected an error for an invalid version")
+	}
+	err := router.TryDeprecateRoute("GET", "1", "nosuchentity", "", Deprecation{})
+	if regErr, isRegErr := err.(*RegistrationError); isRegErr == false || len(regErr.Problems) != 1 || regErr.Problems[0].Num != 3308215947 {
+		t.Error("expected a *RegistrationError for an unknown route, got", err)
+	}
+	if err := router.TryDeprecateRoute("GET", "1", "book", "", Deprecation{}); err != nil {
+		t.Error("unexpected error", err)
+	}
+
+	// removing an entity leaves nothing behind for a later one of the same name
+	router.RegisterChildEntity("author", "book", &BookController{})
+	router.RegisterChildEntity("author", "book", &BookController{})
+	router.DeprecateEntity("1", "author/book", Deprecation{Sunset: time.Now().Add(-time.Hour)})
+	router.DeprecateVersion("2", Deprecation{})
+	conflictsCount := len(router.Conflicts)
+	router.RemoveEntity("author")
+	if len(router.Conflicts) >= conflictsCount || len(router.routes().conflicts) != len(router.Conflicts) {
+		t.Error("expected conflicts of removed entities to be removed, got", len(router.Conflicts), "of", conflictsCount)
+	}
+	for _, conflict := range router.Conflicts {
+		if strings.HasPrefix(conflict.Existing.EntityPath(), "author") {
+			t.Error("expected no conflicts for author, got", conflict)
+		}
+	}
+	if len(router.working.deprecations) != 1 {
+		t.Error("expected only the version's deprecation to remain, got", router.working.deprecations)
+	}
+
+	router.RegisterChildEntity("author", "book", &BookController{})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/author/1/book/1", nil))
+	if w.Code != http.StatusOK || w.Header().Get(HttpHeaderDeprecation) != "" {
+		t.Error("expected re-registered entity not to be deprecated, got", w.Code, w.Header())
+	}
+}
+
+func TestRouterUpdateWhileServing(t *testing.T) {
+	router := makeLibrary(t)
+
+	started := make(chan bool)
+	release := make(chan bool)
+	router.Handle("GET", "1", "slow", "", func(ctx *Context) RouteHandlerResult {
+		started <- true
+		<-release
+		return ctx.MakeRouteHandlerResultOk()
+	}, nil)
+
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	getStatus := func(urlsuffix string) int {
+		response, err := http.Get(ts.URL + urlsuffix)
+		if err != nil {
+			t.Error(err)
+			return 0
+		}
+		ioutil.ReadAll(response.Body)
+		response.Body.Close()
+		return response.StatusCode
+	}
+
+	// in-flight requests finish against the table they started with
+	inFlightStatus := make(chan int)
+	go func() {
+		inFlightStatus <- getStatus("/api/v1/slow")
+	}()
+	<-started
+	if removed := router.RemoveEntity("slow"); removed != 1 {
+		t.Error("expected 1 route removed, got", removed)
+	}
+	close(release)
+	if status := <-inFlightStatus; status != http.StatusOK {
+		t.Error("expected in-flight request to finish w/ 200, got", status)
+	}
+	if status := getStatus("/api/v1/slow"); status != http.StatusNotFound {
+		t.Error("expected 404 after removal, got", status)
+	}
+
+	// registration while serving, each Update is all or nothing
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			router.Update(func(staging *Router) error {
+				staging.RemoveEntity("author")
+				return staging.TryRegisterEntity("author", &AuthorController{})
+			})
+			router.RegisterChildEntity("author", "book", &BookController{})
+			router.RemoveEntity("author/book")
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		if status := getStatus("/api/v1/author/1"); status != http.StatusOK {
+			t.Fatal("expected author to always be routable, got", status)
+		}
+	}
+	<-done
+
+	// failed updates apply nothing
+	err := router.Update(func(staging *Router) error {
+		staging.RemoveEntity("book")
+		return staging.TryRegisterEntity("broken", &BrokenController{})
+	})
+	if err == nil {
+		t.Error("expected update error")
+	}
+	if status := getStatus("/api/v1/book/1"); status != http.StatusOK {
+		t.Error("expected failed update to leave book routable, got", status)
+	}
+	if _, exists := router.Controllers["book"]; exists == false {
+		t.Error("expected failed update to leave book controller")
+	}
+}
+
+// RouteMap and Controllers are copies, the router never reads them
+func TestRouterRouteMapIsACopy(t *testing.T) {
+	router := makeLibrary(t)
+	bookKey := routeKey("GET", "1", "book", "")
+	delete(router.RouteMap, bookKey)
+	delete(router.Controllers, "book")
+	router.RouteMap[routeKey("GET", "1", "bogus", "")] = router.RouteMap[routeKey("GET", "1", "author", "")]
+	routesCount := router.AllRoutesCount()
+
+	// nothing is published until it is needed, so registering at startup doesn't rebuild the table each time
+	router.RegisterEntity("magazine", &MagazineController{})
+	if router.isStale.Load() == false {
+		t.Error("expected registration before serving to be published lazily")
+	}
+	if router.RouteMap[routeKey("GET", "1", "magazine", "")] == nil {
+		t.Error("expected RouteMap to include new routes")
+	}
+	if router.RouteMap[bookKey] != nil {
+		t.Error("expected RouteMap changes to be left alone")
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/book/1", nil))
+	if w.Code != http.StatusOK {
+		t.Error("expected book to be routable, got", w.Code)
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/bogus/1", nil))
+	if w.Code != http.StatusNotFound {
+		t.Error("expected bogus to be unroutable, got", w.Code)
+	}
+	if router.AllRoutesCount() != routesCount+3 {
+		t.Error("expected", routesCount+3, "routes, got", router.AllRoutesCount())
+	}
+
+	// once serving, changes are published right away
+	router.RemoveEntity("magazine")
+	if router.isStale.Load() {
+		t.Error("expected changes while serving to be published right away")
+	}
+}
+
+func TestRouterAcceptVersion(t *testing.T) {
+	router := makeLibrary(t)
+	router.VendorMediaTypeName = "collectivehealth"
+	versionHandler := func(ctx *Context) RouteHandlerResult {
+		return ctx.MakeRouteHandlerResultGenericJSON(map[string]string{"version": ctx.Endpoint.VersionStr})
+	}
+	router.Handle("GET", "1", "edition", "", versionHandler, nil)
+	router.Handle("GET", "2", "edition", "", versionHandler, nil)
+	router.Handle("GET", "", "", "healthz", versionHandler, nil)
+
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	type testCase struct {
+		urlsuffix           string
+		accept              string
+		expectedStatusCode  int
+		expectedVersion     string
+		expectedContentType string
+	}
+	testCases := []testCase{
+		{"/api/edition/1", "application/vnd.collectivehealth.edition.v2+json", http.StatusOK, "2", "application/vnd.collectivehealth.edition.v2+json"},
+		{"/api/edition/1", "application/vnd.collectivehealth.v1+json", http.StatusOK, "1", "application/vnd.collectivehealth.v1+json"},
+		{"/api/edition/1", "application/vnd.collectivehealth.edition.V02+JSON", http.StatusOK, "2", "application/vnd.collectivehealth.edition.v02+json"},
+		{"/api/edition/1", "application/vnd.collectivehealth.edition.v1+json;q=0.5, application/vnd.collectivehealth.edition.v2+json", http.StatusOK, "2", "application/vnd.collectivehealth.edition.v2+json"},
+		{"/api/v1/edition/1", "application/vnd.collectivehealth.edition.v2+json", http.StatusOK, "1", "application/json"}, // path wins
+		{"/api/healthz", "application/vnd.collectivehealth.edition.v2+json", http.StatusOK, "", "application/json"},     // unversioned routes still reachable
+		{"/api/edition/1", "application/vnd.collectivehealth.edition.v7+json", http.StatusNotFound, "", "application/json"}, // errors aren't vendor typed
+		{"/api/edition/1", "application/vnd.collectivehealth.book.v2+json", http.StatusNotFound, "", "application/json"},   // other entities are ignored
+		{"/api/edition/1", "application/vnd.collectivehealth.book.v1+json, application/vnd.collectivehealth.edition.v2+json;q=0.5", http.StatusOK, "2", "application/vnd.collectivehealth.edition.v2+json"},
+		{"/api/edition/1", "application/vnd.somebodyelse.edition.v2+json", http.StatusNotFound, "", "application/json"},
+		{"/api/edition/1", "application/vnd.collectivehealth.edition.v0+json", http.StatusNotFound, "", "application/json"},
+		{"/api/edition/1", "application/json", http.StatusNotFound, "", "application/json"},
+	}
+
+	for _, tc := range testCases {
+		req, _ := http.NewRequest("GET", ts.URL+tc.urlsuffix, nil)
+		req.Header.Set("Accept", tc.accept)
+		response, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bodyBytes, _ := ioutil.ReadAll(response.Body)
+		response.Body.Close()
+		if response.StatusCode != tc.expectedStatusCode {
+			t.Error(tc.urlsuffix, tc.accept, "expected ", tc.expectedStatusCode, ", got", response.StatusCode, string(bodyBytes))
+			continue
+		}
+		if response.Header.Get("Content-Type") != tc.expectedContentType {
+			t.Error(tc.urlsuffix, tc.accept, "expected Content-Type", tc.expectedContentType, ", got", response.Header.Get("Content-Type"))
+		}
+		if response.StatusCode == http.StatusOK {
+			result := map[string]string{}
+			json.Unmarshal(bodyBytes, &result)
+			if result["version"] != tc.expectedVersion {
+				t.Error(tc.urlsuffix, tc.accept, "expected version", tc.expectedVersion, ", got", string(bodyBytes))
+			}
+		}
+	}
+
+	// off by default
+	router.VendorMediaTypeName = ""
+	req, _ := http.NewRequest("GET", ts.URL+"/api/edition/1", nil)
+	req.Header.Set("Accept", "application/vnd.collectivehealth.edition.v2+json")
+	response, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusNotFound || response.Header.Get("Vary") != "" {
+		t.Error("expected negotiation to be off, got", response.StatusCode, response.Header)
+	}
+}
+
+type CatalogController struct {
+}
+
+func (self *CatalogController) ListHandlerV1(ctx *Context) RouteHandlerResult {
+	return ctx.MakeRouteHandlerResultGenericJSON(map[string]string{"handler": "list"})
+}
+func (self *CatalogController) GetHandlerV1(ctx *Context) RouteHandlerResult {
+	return ctx.MakeRouteHandlerResultGenericJSON(map[string]string{"handler": "get"})
+}
+func (self *CatalogController) PutHandlerV1(ctx *Context) RouteHandlerResult {
+	return ctx.MakeRouteHandlerResultGenericJSON(map[string]string{"handler": "put"})
+}
+func (self *CatalogController) DeleteHandlerV1(ctx *Context) RouteHandlerResult {
+	return ctx.MakeRouteHandlerResultGenericJSON(map[string]string{"handler": "delete"})
+}
+func (self *CatalogController) PostHandlerV1(ctx *Context) RouteHandlerResult {
+	return ctx.MakeRouteHandlerResultGenericJSON(map[string]string{"handler": "create"})
+}
+func (self *CatalogController) PostHandlerV1Checkout(ctx *Context) RouteHandlerResult {
+	return ctx.MakeRouteHandlerResultGenericJSON(map[string]string{"handler": "checkout"})
+}
+func (self *CatalogController) GetHandlerV2(ctx *Context) RouteHandlerResult {
+	// no ListHandlerV2, so the primary key is optional
+	return ctx.MakeRouteHandlerResultGenericJSON(map[string]string{"handler": "getv2"})
+}
+
+// no ListHandler, so nothing is enforced
+type ThingController struct {
+}
+
+func (self *ThingController) PostHandlerV1(ctx *Context) RouteHandlerResult {
+	return ctx.MakeRouteHandlerResultGenericJSON(map[string]string{"handler": "post " + ctx.Endpoint.PrimaryKey})
+}
+
+func TestRouterPrimaryKeyPolicy(t *testing.T) {
+	router := NewRouter()
+	router.BasePath = "/api/"
+	router.RegisterEntity("catalog", &CatalogController{})
+	router.RegisterEntity("thing", &ThingController{})
+	router.Handle("GET", "1", "shelf", "", func(ctx *Context) RouteHandlerResult {
+		return ctx.MakeRouteHandlerResultGenericJSON(map[string]string{"handler": "shelves"})
+	}, &RouteOptions{KeyPolicy: PrimaryKeyForbidden})
+
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	type testCase struct {
+		method, urlsuffix   string
+		expectedStatusCode  int
+		expectedHandler     string
+		expectedErrorNumber int64
+	}
+	testCases := []testCase{
+		{"GET", "/api/v1/catalog", http.StatusOK, "list", 0},
+		{"GET", "/api/v1/catalog/", http.StatusOK, "list", 0},
+		{"GET", "/api/v1/catalog/3", http.StatusOK, "get", 0},
+		{"PUT", "/api/v1/catalog/3", http.StatusOK, "put", 0},
+		{"PUT", "/api/v1/catalog/", http.StatusBadRequest, "", BadRequestMissingPrimaryKeyErrorNumber},
+		{"DELETE", "/api/v1/catalog/3", http.StatusOK, "delete", 0},
+		{"DELETE", "/api/v1/catalog", http.StatusBadRequest, "", BadRequestMissingPrimaryKeyErrorNumber},
+		{"POST", "/api/v1/catalog/", http.StatusOK, "create", 0},
+		{"POST", "/api/v1/catalog/3", http.StatusBadRequest, "", BadRequestExtraneousPrimaryKeyErrorNumber},
+		{"POST", "/api/v1/catalog/3/checkout", http.StatusOK, "checkout", 0},
+		{"GET", "/api/v2/catalog/", http.StatusOK, "getv2", 0},
+		{"GET", "/api/v2/catalog/3", http.StatusOK, "getv2", 0},
+		{"GET", "/api/v1/shelf/", http.StatusOK, "shelves", 0},
+		{"GET", "/api/v1/shelf/3", http.StatusBadRequest, "", BadRequestExtraneousPrimaryKeyErrorNumber},
+		{"POST", "/api/v1/thing/", http.StatusOK, "post ", 0},
+		{"POST", "/api/v1/thing/3", http.StatusOK, "post 3", 0},
+	}
+
+	for _, tc := range testCases {
+		req, _ := http.NewRequest(tc.method, ts.URL+tc.urlsuffix, nil)
+		response, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bodyBytes, _ := ioutil.ReadAll(response.Body)
+		response.Body.Close()
+		if response.StatusCode != tc.expectedStatusCode {
+			t.Error(tc.method, tc.urlsuffix, "expected ", tc.expectedStatusCode, ", got", response.StatusCode, string(bodyBytes))
+			continue
+		}
+		if tc.expectedHandler != "" {
+			result := map[string]string{}
+			json.Unmarshal(bodyBytes, &result)
+			if result["handler"] != tc.expectedHandler {
+				t.Error(tc.method, tc.urlsuffix, "expected handler", tc.expectedHandler, ", got", string(bodyBytes))
+			}
+		}
+		if tc.expectedErrorNumber != 0 {
+			pw := new(PayloadWrapper)
+			json.Unmarshal(bodyBytes, pw)
+			if pw.ErrorNumber != tc.expectedErrorNumber {
+				t.Error(tc.method, tc.urlsuffix, "expected error number", tc.expectedErrorNumber, ", got", string(bodyBytes))
+			}
+		}
+	}
+
+	policies := map[string]string{}
+	for _, info := range router.Routes() {
+		policies[info.Method+" "+info.Path+" "+info.HandlerName] = info.PrimaryKey
+	}
+	if policies["GET /api/v1/catalog/ ListHandlerV1"] != "forbidden" || policies["GET /api/v1/catalog/ GetHandlerV1"] != "required" || policies["GET /api/v2/catalog/ GetHandlerV2"] != "optional" {
+		t.Error("unexpected primary key policies", policies)
+	}
+}
+
+type VolumeController struct {
+}
+
+func (self *VolumeController) ParsePrimaryKey(rawKey string) (interface{}, error) {
+	return ParseInt64PrimaryKey(rawKey)
+}
+func (self *VolumeController) GetHandlerV1(ctx *Context) RouteHandlerResult {
+	key, ok := ctx.Endpoint.PrimaryKeyInt64()
+	return ctx.MakeRouteHandlerResultGenericJSON(map[string]interface{}{"key": key, "ok": ok})
+}
+
+func TestRouterTypedPrimaryKeys(t *testing.T) {
+	router := NewRouter()
+	router.BasePath = "/api/"
+	router.RegisterEntity("volume", &VolumeController{})
+	router.Handle("GET", "1", "member", "", func(ctx *Context) RouteHandlerResult {
+		key, ok := ctx.Endpoint.PrimaryKeyUUID()
+		return ctx.MakeRouteHandlerResultGenericJSON(map[string]interface{}{"key": key.String(), "ok": ok})
+	}, &RouteOptions{KeyParser: ParseUUIDPrimaryKey})
+
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	type testCase struct {
+		urlsuffix          string
+		expectedStatusCode int
+		expectedKey        interface{}
+	}
+	testCases := []testCase{
+		{"/api/v1/volume/42", http.StatusOK, float64(42)},
+		{"/api/v1/volume/-7", http.StatusOK, float64(-7)},
+		{"/api/v1/volume/", http.StatusOK, float64(0)}, // no key, nothing to parse
+		{"/api/v1/volume/forty-two", http.StatusBadRequest, nil},
+		{"/api/v1/volume/99999999999999999999", http.StatusBadRequest, nil},
+		{"/api/v1/member/6BA7B810-9DAD-11D1-80B4-00C04FD430C8", http.StatusOK, "6ba7b810-9dad-11d1-80b4-00c04fd430c8"},
+		{"/api/v1/member/6ba7b8109dad11d180b400c04fd430c8", http.StatusBadRequest, nil},
+		{"/api/v1/member/6ba7b810-9dad-11d1-80b4-00c04fd430zz", http.StatusBadRequest, nil},
+		// misplaced dashes, which would otherwise decode to a different UUID
+		{"/api/v1/member/6ba7b810-9dad-11d1-80b4-00c04fd4-0-8", http.StatusBadRequest, nil},
+		{"/api/v1/member/6ba7b810-9dad-11d1-80b4-00c0-4fd4-30", http.StatusBadRequest, nil},
+		{"/api/v1/member/6ba7b810-9dad-11d1-80b4-00c04fd430c", http.StatusBadRequest, nil},
+		{"/api/v1/member/6ba7b8109-dad-11d1-80b4-00c04fd430c8", http.StatusBadRequest, nil},
+	}
+
+	for _, tc := range testCases {
+		response, err := http.Get(ts.URL + tc.urlsuffix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bodyBytes, _ := ioutil.ReadAll(response.Body)
+		response.Body.Close()
+		if response.StatusCode != tc.expectedStatusCode {
+			t.Error(tc.urlsuffix, "expected ", tc.expectedStatusCode, ", got", response.StatusCode, string(bodyBytes))
+			continue
+		}
+		if tc.expectedStatusCode == http.StatusBadRequest {
+			pw := new(PayloadWrapper)
+			json.Unmarshal(bodyBytes, pw)
+			if pw.ErrorNumber != BadRequestMalformedPrimaryKeyErrorNumber || pw.ErrorMessage != BadRequestMalformedPrimaryKeyPrefix {
+				t.Error(tc.urlsuffix, "expected malformed primary key error, got", string(bodyBytes))
+			}
+			continue
+		}
+		result := map[string]interface{}{}
+		json.Unmarshal(bodyBytes, &result)
+		if result["key"] != tc.expectedKey {
+			t.Error(tc.urlsuffix, "expected key", tc.expectedKey, ", got", string(bodyBytes))
+		}
+	}
+}
+
+// the body is no longer the handler's to read, once the 504 was sent
+func TestRouterTimeoutRequestBody(t *testing.T) {
+	router := NewRouter()
+	router.BasePath = "/api/"
+
+	served := make(chan struct{})
+	readErrors := make(chan error, 1)
+	router.Handle("POST", "1", "", "upload", func(ctx *Context) RouteHandlerResult {
+		<-ctx.StdContext().Done()
+		<-served
+		_, err := ioutil.ReadAll(ctx.RequestBodyReader())
+		readErrors <- err
+		return ctx.MakeRouteHandlerResultOk()
+	}, &RouteOptions{Timeout: 20 * time.Millisecond})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/upload", bytes.NewBufferString("Hello World!")))
+	close(served)
+	if w.Code != http.StatusGatewayTimeout {
+		t.Error("expected 504, got", w.Code, w.Body.String())
+	}
+	if err := <-readErrors; err != http.ErrHandlerTimeout {
+		t.Error("expected reads after the timeout to fail w/ http.ErrHandlerTimeout, got", err)
+	}
+}
+
+// never ends, one byte at a time
+type endlessBody struct{}
+
+func (endlessBody) Read(p []byte) (int, error) {
+	time.Sleep(time.Millisecond)
+	if len(p) == 0 {
+		return 0, nil
+	}
+	p[0] = 'x'
+	return 1, nil
+}
+
+// meant for go test -race: the handler ignores its deadline and keeps reading, while the body is failed and drained
+func TestRouterTimeoutRequestBodyRace(t *testing.T) {
+	router := NewRouter()
+	router.BasePath = "/api/"
+
+	readErrors := make(chan error, 1)
+	router.Handle("POST", "1", "", "upload", func(ctx *Context) RouteHandlerResult {
+		reader := ctx.RequestBodyReader()
+		buf := make([]byte, 1)
+		for {
+			if _, err := reader.Read(buf); err != nil {
+				readErrors <- err
+				return ctx.MakeRouteHandlerResultOk()
+			}
+		}
+	}, &RouteOptions{Timeout: 20 * time.Millisecond})
+
+	req := httptest.NewRequest("POST", "/api/v1/upload", endlessBody{})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusGatewayTimeout {
+		t.Error("expected 504, got", w.Code, w.Body.String())
+	}
+
+	// like the server draining the body, while the handler is still reading it
+	if _, err := io.Copy(ioutil.Discard, req.Body); err != http.ErrHandlerTimeout {
+		t.Error("expected draining after the timeout to fail w/ http.ErrHandlerTimeout, got", err)
+	}
+	if err := <-readErrors; err != http.ErrHandlerTimeout {
+		t.Error("expected the handler's reads to fail w/ http.ErrHandlerTimeout, got", err)
+	}
+}
+
+type timeoutTestKey struct{}
+
+type stdContextPreProcessor struct{}
+
+func (sc *stdContextPreProcessor) Process(ctx *Context) (terminateEarly bool, derr *deeperror.DeepError) {
+	ctx.SetStdContext(context.WithValue(ctx.StdContext(), timeoutTestKey{}, "from middleware"))
+	return false, nil
+}
+
+func TestRouterTimeout(t *testing.T) {
+	router := NewRouter()
+	router.BasePath = "/api/"
+	router.PreProcessors = append(router.PreProcessors, &stdContextPreProcessor{})
+
+	cancelled := make(chan error, 1)
+	router.Handle("GET", "1", "", "slow", func(ctx *Context) RouteHandlerResult {
+		select {
+		case <-ctx.StdContext().Done():
+			cancelled <- ctx.StdContext().Err()
+		case <-time.After(time.Second):
+			cancelled <- nil
+		}
+		return ctx.MakeRouteHandlerResultGenericJSON(map[string]string{"too": "late"})
+	}, &RouteOptions{Timeout: 20 * time.Millisecond})
+	router.Handle("GET", "1", "", "fast", func(ctx *Context) RouteHandlerResult {
+		value, _ := ctx.StdContext().Value(timeoutTestKey{}).(string)
+		if ctx.Req.Context().Value(timeoutTestKey{}) != value {
+			t.Error("expected ctx.Req to carry the std context")
+		}
+		ctx.AddResponseHeader("X-Fast", "yes")
+		return ctx.MakeRouteHandlerResultGenericJSON(map[string]string{"value": value})
+	}, &RouteOptions{Timeout: time.Second})
+
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	// slow
+	response, err := http.Get(ts.URL + "/api/v1/slow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bodyBytes, _ := ioutil.ReadAll(response.Body)
+	response.Body.Close()
+	if response.StatusCode != http.StatusGatewayTimeout {
+		t.Fatal("expected 504, got", response.StatusCode, string(bodyBytes))
+	}
+	pw := new(PayloadWrapper)
+	json.Unmarshal(bodyBytes, pw)
+	if pw.ErrorNumber != GatewayTimeoutErrorNumber || pw.ErrorMessage != GatewayTimeoutPrefix {
+		t.Error("expected gateway timeout error, got", string(bodyBytes))
+	}
+	if err := <-cancelled; err != context.DeadlineExceeded {
+		t.Error("expected handler's context to be cancelled w/ DeadlineExceeded, got", err)
+	}
+
+	// fast
+	response, err = http.Get(ts.URL + "/api/v1/fast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bodyBytes, _ = ioutil.ReadAll(response.Body)
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Fatal("expected 200, got", response.StatusCode, string(bodyBytes))
+	}
+	if response.Header.Get("X-Fast") != "yes" {
+		t.Error("expected buffered headers to be written, got", response.Header)
+	}
+	if strings.Contains(string(bodyBytes), "from middleware") == false {
+		t.Error("expected value from middleware, got", string(bodyBytes))
+	}
+
+	for _, info := range router.Routes() {
+		if info.Action == "slow" && info.Timeout != "20ms" {
+			t.Error("expected Routes() to include timeout, got", info.Timeout)
+		}
+	}
+}
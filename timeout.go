@@ -0,0 +1,144 @@
+package eprouter
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"sync"
+)
+
+const (
+	ServiceUnavailablePrefix      = "503 Service Unavailable"
+	ServiceUnavailableErrorNumber = 5030000503
+	GatewayTimeoutPrefix          = "504 Gateway Timeout"
+	GatewayTimeoutErrorNumber     = 5040000504
+)
+
+// Runs the handler (and writes its result) w/ a deadline of routePtr.Timeout.
+//
+// The handler runs in its own goroutine, on a shallow copy of ctx whose writes are buffered.
+// If it finishes in time, the buffered response is written out and the copy's state is adopted.
+// Otherwise its std context is cancelled, and we answer 504 (or 503 if the request itself was cancelled).
+// Anything the handler writes after that is discarded, and its reads of the request body fail w/ http.ErrHandlerTimeout.
+//
+// The handler's goroutine can't be stopped from here; it keeps running until it returns.
+// Handlers of routes w/ a timeout must stop once StdContext().Done() is closed, and not touch ctx.Req afterwards.
+// Since the whole response is buffered, such routes can't stream their responses either.
+func (router *Router) runHandlerWithTimeout(ctx *Context, routePtr *Route) {
+	stdCtx, cancel := context.WithTimeout(ctx.StdContext(), routePtr.Timeout)
+	defer cancel()
+
+	bufferedWriter := newTimeoutResponseWriter(ctx.w.Header())
+	ctx.valueStore() // so values and cleanups set by the handler are shared w/ ctx
+	handlerCtx := *ctx
+	handlerCtx.w = bufferedWriter
+	handlerCtx.SetStdContext(stdCtx)
+
+	done := make(chan struct{})
+	panics := make(chan interface{}, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				panics <- p
+			}
+			close(done)
+		}()
+		router.runHandler(&handlerCtx, routePtr)
+	}()
+
+	select {
+	case <-done:
+		select {
+		case p := <-panics:
+			// same as if there were no timeout
+			panic(p)
+		default:
+		}
+		w := ctx.w
+		originalStdCtx := ctx.stdCtx
+		originalReq := ctx.Req
+		*ctx = handlerCtx
+		ctx.w = w
+		ctx.stdCtx = originalStdCtx
+		ctx.Req = originalReq
+		bufferedWriter.flushTo(w)
+	case <-stdCtx.Done():
+		bufferedWriter.discard()
+		if ctx.limitedBody != nil {
+			// the server may reuse or drain the body once ServeHTTP returns
+			ctx.limitedBody.fail(http.ErrHandlerTimeout)
+		}
+		ctx.handlerDone = done
+		if stdCtx.Err() == context.DeadlineExceeded {
+			ctx.SendSimpleErrorPayload(http.StatusGatewayTimeout, GatewayTimeoutErrorNumber, GatewayTimeoutPrefix)
+		} else {
+			ctx.SendSimpleErrorPayload(http.StatusServiceUnavailable, ServiceUnavailableErrorNumber, ServiceUnavailablePrefix)
+		}
+	}
+}
+
+// Buffers the whole response so that nothing reaches the client unless the handler finishes in time.
+type timeoutResponseWriter struct {
+	mutex      sync.Mutex
+	header     http.Header
+	body       bytes.Buffer
+	statusCode int
+	discarded  bool
+}
+
+// starts w/ a copy of any headers already set (eg: by middleware)
+func newTimeoutResponseWriter(header http.Header) *timeoutResponseWriter {
+	tw := new(timeoutResponseWriter)
+	tw.header = make(http.Header, len(header))
+	for key, values := range header {
+		tw.header[key] = append([]string(nil), values...)
+	}
+	return tw
+}
+
+func (tw *timeoutResponseWriter) Header() http.Header {
+	return tw.header
+}
+
+func (tw *timeoutResponseWriter) WriteHeader(code int) {
+	tw.mutex.Lock()
+	defer tw.mutex.Unlock()
+	if tw.statusCode == 0 {
+		tw.statusCode = code
+	}
+}
+
+func (tw *timeoutResponseWriter) Write(b []byte) (int, error) {
+	tw.mutex.Lock()
+	defer tw.mutex.Unlock()
+	if tw.discarded {
+		return 0, http.ErrHandlerTimeout
+	}
+	if tw.statusCode == 0 {
+		tw.statusCode = http.StatusOK
+	}
+	return tw.body.Write(b)
+}
+
+func (tw *timeoutResponseWriter) discard() {
+	tw.mutex.Lock()
+	defer tw.mutex.Unlock()
+	tw.discarded = true
+}
+
+// only called once the handler has returned, so the header map is no longer in use
+func (tw *timeoutResponseWriter) flushTo(w http.ResponseWriter) {
+	header := w.Header()
+	for key := range header {
+		delete(header, key)
+	}
+	for key, values := range tw.header {
+		header[key] = values
+	}
+	if tw.statusCode == 0 {
+		// nothing was written
+		return
+	}
+	w.WriteHeader(tw.statusCode)
+	w.Write(tw.body.Bytes())
+}